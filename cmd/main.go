@@ -29,7 +29,7 @@ func main() {
 	if err != nil {
 		glog.Fatal(err)
 	}
-	controller.Crawlers[crawler.Conf.CrawlerName] = crawler
+	controller.Crawlers.Put(&crawler)
 	glog.Info("run!")
 	controller.Run()
 }
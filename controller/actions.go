@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"github.com/crawlerclub/x/types"
+	"github.com/liuzl/store"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"os"
+	"time"
+)
+
+// states of running crawlers, they are not persisted and a crawler is
+// running again after it is updated or the controller restarts
+const (
+	StateRunning = "running"
+	// not scheduled, its queue is kept
+	StatePaused = "paused"
+	// scheduled to finish its queue, but no new tasks are accepted
+	StateDraining = "draining"
+)
+
+// resetStores are cleared for a crawler by Reset, their keys are prefixed
+// by crawler name
+var resetStores = []string{"seed", "sitemap", "seen", "fingerprint", "failed", "disallowed", "next_run"}
+
+func (self *Controller) GetCrawlerItem(name string) (*types.CrawlerItem, error) {
+	value, err := self.Stores["crawler"].Get(name)
+	if err != nil {
+		return nil, err
+	}
+	var item types.CrawlerItem
+	if err = store.BytesToObject(value, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func schedItem(item *types.CrawlerItem) Item {
+	return Item{
+		CrawlerName:    item.CrawlerName,
+		Weight:         item.Weight,
+		MaxConcurrency: item.MaxConcurrency,
+	}
+}
+
+// schedule puts crawler name back to scheduler with its weight
+func (self *Controller) schedule(name string) error {
+	item, err := self.GetCrawlerItem(name)
+	if err != nil {
+		return err
+	}
+	self.Schduler.Remove(name)
+	return self.Schduler.Insert(schedItem(item))
+}
+
+// acceptsTasks reports whether new tasks can be enqueued for crawler name
+func (self *Controller) acceptsTasks(name string) bool {
+	return self.Crawlers.State(name) != StateDraining
+}
+
+// Pause stops scheduling crawler name, its queue is kept
+func (self *Controller) Pause(name string) error {
+	if !self.Crawlers.SetState(name, StatePaused) {
+		return ErrCrawlerNotRunning
+	}
+	self.Schduler.Remove(name)
+	return nil
+}
+
+// Resume schedules a paused or draining crawler as usual, the loading of its
+// urls_file stopped by Drain goes on
+func (self *Controller) Resume(name string) error {
+	if !self.Crawlers.SetState(name, StateRunning) {
+		return ErrCrawlerNotRunning
+	}
+	if c, release, ok := self.Crawlers.Acquire(name); ok {
+		if c.Conf.CrawlerType == "url_set" {
+			self.startFeeder(c)
+		}
+		release()
+	}
+	return self.schedule(name)
+}
+
+// Drain keeps crawler name scheduled until its queue is finished, but the
+// tasks it generates, revisits and urls from feeders are dropped
+func (self *Controller) Drain(name string) error {
+	if !self.Crawlers.SetState(name, StateDraining) {
+		return ErrCrawlerNotRunning
+	}
+	return self.schedule(name)
+}
+
+// SetWeight changes the weight of crawler name and applies it to the running
+// crawler without restarting it, a crawler of weight 0 is not scheduled
+func (self *Controller) SetWeight(name string, weight int) error {
+	if weight < 0 {
+		return ErrNegativeWeight
+	}
+	item, err := self.GetCrawlerItem(name)
+	if err != nil {
+		return err
+	}
+	item.Weight = weight
+	item.ModifyTime = time.Now().Unix()
+	value, err := store.ObjectToBytes(item)
+	if err != nil {
+		return err
+	}
+	if err = self.Stores["crawler"].Put(name, value); err != nil {
+		return err
+	}
+	switch self.Crawlers.State(name) {
+	case "":
+		// not run with weight 0
+		if item.Status == "enabled" && weight > 0 {
+			return self.runCrawler(item)
+		}
+		return nil
+	case StatePaused:
+		// applied on Resume
+		return nil
+	}
+	if err = self.Schduler.SetWeight(name, weight); err == ErrNameNotFound {
+		// not inserted with weight 0
+		return self.schedule(name)
+	}
+	return err
+}
+
+// Reset closes crawler name, clears its queue, seeds, crontab, pending
+// retries, failed and disallowed tasks, stats, login session and the progress
+// of urls_file, sitemaps and dedup, then runs it from its start_urls again if
+// it is enabled
+func (self *Controller) Reset(name string) error {
+	item, err := self.GetCrawlerItem(name)
+	if err != nil {
+		return err
+	}
+	if err = self.CloseCrawler(name); err != nil {
+		return err
+	}
+	if err = os.RemoveAll(self.workDir + "/queue/" + name); err != nil {
+		return err
+	}
+	prefix := util.BytesPrefix([]byte(name + "\t"))
+	for _, s := range resetStores {
+		if err = self.deleteKeys(s, prefix, nil); err != nil {
+			return err
+		}
+	}
+	self.Stores["urls_file"].Delete(name)
+	self.Stores["stats"].Delete(name)
+	self.Stores["session"].Delete(name)
+	// keys of crontab and running are prefixed by time
+	for _, s := range []string{"crontab", "running"} {
+		err = self.deleteKeys(s, nil, func(value []byte) bool {
+			var task types.Task
+			return store.BytesToObject(value, &task) == nil && task.CrawlerName == name
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if item.Status == "enabled" && item.Weight > 0 {
+		return self.runCrawler(item)
+	}
+	return nil
+}
+
+// deleteKeys deletes the keys in r of store name whose values match fn, all
+// keys in r are deleted if fn is nil
+func (self *Controller) deleteKeys(name string, r *util.Range, fn func(value []byte) bool) error {
+	var keys []string
+	err := self.Stores[name].ForEach(r, func(key, value []byte) (bool, error) {
+		if fn == nil || fn(value) {
+			keys = append(keys, string(key))
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = self.Stores[name].Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
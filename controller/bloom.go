@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// bloomFilter is a concurrent safe bloom filter of strings, it never reports
+// false negatives, so a negative Test saves a lookup of the seen store
+type bloomFilter struct {
+	sync.RWMutex
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint64 // number of hash functions
+}
+
+// newBloomFilter creates a filter for n items with false positive rate p
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n <= 0 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Ceil(math.Ln2 * float64(m) / float64(n)))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// locations returns the k bit positions of s by double hashing
+func (self *bloomFilter) locations(s string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	locs := make([]uint64, self.k)
+	for i := uint64(0); i < self.k; i++ {
+		locs[i] = (h1 + i*h2) % self.m
+	}
+	return locs
+}
+
+func (self *bloomFilter) Add(s string) {
+	locs := self.locations(s)
+	self.Lock()
+	defer self.Unlock()
+	for _, l := range locs {
+		self.bits[l/64] |= 1 << (l % 64)
+	}
+}
+
+// Test reports whether s may have been added
+func (self *bloomFilter) Test(s string) bool {
+	locs := self.locations(s)
+	self.RLock()
+	defer self.RUnlock()
+	for _, l := range locs {
+		if self.bits[l/64]&(1<<(l%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -3,6 +3,7 @@ package controller
 import (
 	"errors"
 	"github.com/crawlerclub/x/crawler"
+	"github.com/crawlerclub/x/metrics"
 	"github.com/crawlerclub/x/types"
 	"github.com/golang/glog"
 	"github.com/liuzl/store"
@@ -21,22 +22,44 @@ var (
 	ErrNamesNotSame   = errors.New("controller/controller.go CrawlerNames are not the same")
 	ErrNameTaken      = errors.New("controller/controller.go CrawlerName already taken")
 	ErrNoName         = errors.New("controller/controller.go no CrawlerName")
+	ErrNegativeWeight = errors.New("controller/controller.go weight must not be negative")
+	ErrStopped        = errors.New("controller/controller.go controller is stopped")
 )
 
-var StoreNames = []string{"crawler", "seed", "running", "crontab"}
+var StoreNames = []string{"crawler", "seed", "running", "crontab", "urls_file", "sitemap", "disallowed", "seen", "failed", "stats", "fingerprint", "session", "next_run"}
 
 type Controller struct {
-	Crawlers    map[string]crawler.Crawler
+	Crawlers    *CrawlerRegistry
 	Schduler    CrawlerScheduler
 	Stores      map[string]*store.LevelStore
 	WorkerCount int
+	// time to wait for running tasks when stopping, 30s if 0
+	ShutdownTimeout time.Duration
 
-	workDir  string
-	isInited bool
+	workDir   string
+	isInited  bool
+	blooms    map[string]*bloomFilter
+	bloomLock sync.Mutex
+	// urls_file feeders running, true if they are to run again
+	feeders  map[*crawler.Crawler]bool
+	feedLock sync.Mutex
+
+	exitCh      chan int
+	stopOnce    sync.Once
+	closeLock   sync.RWMutex // held by writers of queues and stores
+	stopped     bool         // no more writes to queues and stores
+	running     map[int]*runningTask
+	runningLock sync.Mutex
+	// sizes of metricStores counted by statsLoop
+	storeSizes map[string]int
+	sizesLock  sync.Mutex
 }
 
+// timeLayout is the layout of time prefixes of running and crontab keys
+const timeLayout = "20060102150405"
+
 func timeStr(t int64) string {
-	return time.Unix(t, 0).Format("20060102030405")
+	return time.Unix(t, 0).Format(timeLayout)
 }
 
 func (self *Controller) Init(dir string, wc int) error {
@@ -46,6 +69,8 @@ func (self *Controller) Init(dir string, wc int) error {
 	}
 	self.workDir = dir
 	self.WorkerCount = wc
+	self.exitCh = make(chan int)
+	self.running = make(map[int]*runningTask)
 	_, err := os.Stat(dir)
 	if os.IsNotExist(err) {
 		err = os.MkdirAll(dir, 0755)
@@ -54,6 +79,8 @@ func (self *Controller) Init(dir string, wc int) error {
 		}
 	}
 	self.Stores = make(map[string]*store.LevelStore)
+	self.blooms = make(map[string]*bloomFilter)
+	self.feeders = make(map[*crawler.Crawler]bool)
 	for _, name := range StoreNames {
 		self.Stores[name], err = store.NewLevelStore(dir + "/db/" + name)
 		if err != nil {
@@ -61,6 +88,9 @@ func (self *Controller) Init(dir string, wc int) error {
 		}
 	}
 
+	if err = self.indexNextRuns(); err != nil {
+		return err
+	}
 	err = self.initCrawlersFromDB()
 	if err != nil {
 		return err
@@ -71,27 +101,41 @@ func (self *Controller) Init(dir string, wc int) error {
 
 func (self *Controller) runCrawler(item *types.CrawlerItem) error {
 	glog.Info("call runCrawler: ", item.CrawlerName)
-	var crawler crawler.Crawler
-	crawler.Conf = &item.Conf
-	err := crawler.InitEs()
+	item.Conf.Compile()
+	c := &crawler.Crawler{Conf: &item.Conf, Stats: self.loadStats(item.CrawlerName),
+		Exit: self.exitCh}
+	err := c.InitSinks()
 	if err != nil {
-		//return err
-		glog.Error(err)
+		// not run without sinks, or items would be dropped
+		glog.Error(item.CrawlerName, " sinks: ", err)
+		return err
+	}
+	if item.Conf.Login.Enabled() {
+		c.Session, err = crawler.NewSession(item.CrawlerName, &item.Conf.Login, self.Stores["session"])
+		if err != nil {
+			glog.Error(err)
+			c.Close()
+			return err
+		}
 	}
 	dir := self.workDir + "/queue"
-	err = crawler.InitTaskQueue(dir)
+	err = c.InitTaskQueue(dir)
 	if err != nil {
 		glog.Error(err)
+		c.Close()
 		return err
 	}
-	if _, ok := self.Crawlers[item.CrawlerName]; ok {
-		self.Schduler.Remove(item.CrawlerName)
+	self.Schduler.Remove(item.CrawlerName)
+	self.loadBloomFilter(&item.Conf)
+	self.Crawlers.Put(c)
+	release, ok := self.Crawlers.Hold(c)
+	if !ok {
+		// replaced by another update
+		return nil
 	}
-	self.Crawlers[item.CrawlerName] = crawler
-	var sitem Item
-	sitem.CrawlerName = item.CrawlerName
-	sitem.Weight = item.Weight
-	glog.Info("SortedList add ", sitem)
+	defer release()
+	sitem := schedItem(item)
+	glog.Info("scheduler add ", sitem)
 	err = self.Schduler.Insert(sitem)
 	if err != nil {
 		glog.Error(err)
@@ -106,10 +150,13 @@ func (self *Controller) runCrawler(item *types.CrawlerItem) error {
 		}
 		if ret, _ := self.Stores["seed"].Has(task.Id()); !ret {
 			// enqueue new start urls
-			if _, err = crawler.TaskQueue.EnqueueObject(task); err != nil {
+			if err = self.enqueue(c, task); err != nil {
 				glog.Error(err)
 				return err
 			}
+			if key := seenKey(&item.Conf, url); key != "" {
+				self.markSeen(item.CrawlerName, key, time.Now().Unix())
+			}
 		}
 		value, _ := store.ObjectToBytes(task)
 		self.Stores["seed"].Put(task.Id(), value) // update seed
@@ -125,15 +172,16 @@ func (self *Controller) runCrawler(item *types.CrawlerItem) error {
 			return false, e
 		}
 		if p, ok := item.Conf.ParseConfs[t.ParserName]; ok {
-			if p.RevisitInterval != t.RevisitInterval {
+			if p.RevisitInterval != t.RevisitInterval || p.RevisitSchedule() != t.RevisitCron {
 				t.RevisitInterval = p.RevisitInterval
+				t.RevisitCron = p.RevisitSchedule()
 				v, e := store.ObjectToBytes(t)
 				if e != nil {
 					return false, e
 				}
 				self.Stores["seed"].Put(t.Id(), v)
-				if p.RevisitInterval > 0 {
-					if _, e = crawler.TaskQueue.EnqueueObject(t); e != nil {
+				if p.Revisits() {
+					if e = self.enqueue(c, t); e != nil {
 						return false, e
 					}
 				}
@@ -143,13 +191,21 @@ func (self *Controller) runCrawler(item *types.CrawlerItem) error {
 	})
 	if err != nil {
 		glog.Error(err)
+		return err
 	}
-	return err
+
+	if item.Conf.CrawlerType == "url_set" {
+		// urls_file may be huge, load it in background
+		self.startFeeder(c)
+	} else if item.Conf.Sitemap.Enabled() {
+		go self.discoverSitemaps(c)
+	}
+	return nil
 }
 
 func (self *Controller) initCrawlersFromDB() error {
 	glog.Info("call initCrawlersFromDB")
-	self.Crawlers = make(map[string]crawler.Crawler)
+	self.Crawlers = NewCrawlerRegistry()
 	self.Schduler.Init()
 
 	crawlerStore := self.Stores["crawler"]
@@ -164,9 +220,9 @@ func (self *Controller) initCrawlersFromDB() error {
 		}
 		if item.Status == "enabled" {
 			enabled += 1
-			e = self.runCrawler(&item)
-			if e != nil {
-				return false, nil
+			if e = self.runCrawler(&item); e != nil {
+				// the other crawlers are still run
+				glog.Error(item.CrawlerName, " not run: ", e)
 			}
 		}
 		return true, nil
@@ -178,23 +234,43 @@ func (self *Controller) initCrawlersFromDB() error {
 	return nil
 }
 
+// CloseCrawler stops scheduling crawler name and waits until the workers
+// using its queue release it, then its queue is closed. Workers do not hold
+// it while downloading, so it returns without waiting for downloads.
 func (self *Controller) CloseCrawler(name string) error {
-	err := self.Schduler.Remove(name)
-	if err != nil && err != ErrNameNotFound {
-		return err
+	if done, ok := self.closeCrawler(name); ok {
+		<-done
 	}
-	if crawler, ok := self.Crawlers[name]; ok {
-		crawler.Close()
-	}
-	delete(self.Crawlers, name)
 	return nil
 }
 
+func (self *Controller) closeCrawler(name string) (<-chan struct{}, bool) {
+	self.Schduler.Remove(name)
+	if c, release, ok := self.Crawlers.Acquire(name); ok {
+		self.saveStats(c)
+		release()
+	}
+	done, ok := self.Crawlers.Remove(name)
+	self.bloomLock.Lock()
+	delete(self.blooms, name)
+	self.bloomLock.Unlock()
+	return done, ok
+}
+
+// DelCrawler closes crawler name and deletes it with its revisit states and
+// login session
 func (self *Controller) DelCrawler(name string) error {
 	err := self.CloseCrawler(name)
 	if err != nil {
 		return err
 	}
+	err = self.deleteKeys("fingerprint", util.BytesPrefix([]byte(name+"\t")), nil)
+	if err != nil {
+		return err
+	}
+	if err = self.Stores["session"].Delete(name); err != nil {
+		return err
+	}
 	return self.Stores["crawler"].Delete(name)
 }
 
@@ -245,13 +321,28 @@ func (self *Controller) UpdateCrawler(item *types.CrawlerItem, isNew bool) error
 	return err
 }
 
+// Finish closes the crawlers, which flushes their sinks, and the stores. It
+// must be called after Run returns.
 func (self *Controller) Finish() {
 	if !self.isInited {
 		return
 	}
+	self.closeLock.Lock()
+	defer self.closeLock.Unlock()
+	if self.Stores == nil {
+		// already finished
+		return
+	}
+	self.stopped = true
+	self.saveAllStats()
+	// workers stuck after shutdown timeout close their crawlers on release
+	for _, name := range self.Crawlers.Names() {
+		self.closeCrawler(name)
+	}
 	for _, v := range self.Stores {
 		v.Close()
 	}
+	self.Stores = nil
 }
 
 func (self *Controller) enqueueTask(wg *sync.WaitGroup, exitCh chan int, name string) {
@@ -268,19 +359,33 @@ func (self *Controller) enqueueTask(wg *sync.WaitGroup, exitCh chan int, name st
 			return
 		default:
 			glog.Info("begin ", name)
-			now := time.Now().Format("20060102030405")
+			self.closeLock.RLock()
+			if self.stopped {
+				self.closeLock.RUnlock()
+				return
+			}
+			now := timeStr(time.Now().Unix())
 			self.Stores[name].ForEach(&util.Range{Limit: []byte(now)},
 				func(key, value []byte) (bool, error) {
 					var task types.Task
 					store.BytesToObject(value, &task)
-					if crawler, ok := self.Crawlers[task.CrawlerName]; ok {
-						crawler.TaskQueue.EnqueueObject(task)
+					if name == "crontab" && !self.acceptsTasks(task.CrawlerName) {
+						// revisit it after draining
+						return true, nil
+					}
+					if c, release, ok := self.Crawlers.Acquire(task.CrawlerName); ok {
+						self.enqueue(c, task)
+						release()
+					}
+					if name == "crontab" {
+						self.unscheduleSeed(string(key))
 					}
 					self.Stores[name].Delete(string(key))
 					return true, nil
 				})
+			self.closeLock.RUnlock()
 			// every 5 seconds
-			time.Sleep(5 * time.Second)
+			sleep(exitCh, 5*time.Second)
 		}
 	}
 }
@@ -307,77 +412,176 @@ func (self *Controller) startWorker(worker int, wg *sync.WaitGroup, exitCh chan
 			return
 		default:
 			glog.Info("Work on next task! worker: ", worker)
-			name, err := self.Schduler.WeightedChoice()
+			name, err := self.Schduler.Next(exitCh)
+			if err == ErrSchedulerExit {
+				return
+			}
 			if err != nil {
 				glog.Error(err)
-				time.Sleep(10 * time.Second)
+				sleep(exitCh, 10*time.Second)
 				continue
 			}
 			glog.Info("worker ", worker, " is working on ", name)
-			if crawler, ok := self.Crawlers[name]; ok {
-				item, err := crawler.TaskQueue.Dequeue()
-				if err != nil {
-					glog.Error(err)
-					time.Sleep(20 * time.Second)
-					continue
-				}
-				var task types.Task
-				err = item.ToObject(&task)
-				if err != nil {
-					glog.Error(err)
-					continue
-				}
-				now := time.Now().Unix()
-				key := timeStr(now+300) + "\t" + task.Id()
-				value, _ := store.ObjectToBytes(task)
-				self.Stores["running"].Put(key, value)
-
-				glog.Info("process task:", task)
-				tasks, items, err := crawler.Process(&task)
-				if err != nil {
+			metrics.SchedulerChoices.WithLabelValues(name).Inc()
+			c, release, ok := self.Crawlers.Acquire(name)
+			if !ok {
+				self.Schduler.Done(name)
+				glog.Error("No crawler named: ", name)
+				continue
+			}
+			task, err := self.dequeue(worker, c)
+			if err != nil {
+				empty := self.idle(c)
+				release()
+				self.Schduler.Done(name)
+				if !empty {
 					glog.Error(err)
-					continue
-				}
-				// remove task from Running
-				self.Stores["running"].Delete(key)
-
-				if parseConf, ok := crawler.Conf.ParseConfs[task.ParserName]; ok {
-					if parseConf.RevisitInterval > 0 && task.IsSeedUrl {
-						// add this task back to crontab
-						task.LastAccessTime = now
-						task.RevisitInterval = parseConf.RevisitInterval
-						key = timeStr(now+task.RevisitInterval) + "\t" + task.Id()
-						value, _ = store.ObjectToBytes(task)
-						self.Stores["crontab"].Put(key, value)
-					}
-				}
-				for _, t := range tasks {
-					glog.Info("enqueue task:", t)
-					// add SeedUrl to Seed
-					if t.IsSeedUrl {
-						value, _ = store.ObjectToBytes(t)
-						self.Stores["seed"].Put(t.Id(), value)
-					}
-					crawler.TaskQueue.EnqueueObject(t)
-				}
-				for _, item := range items {
-					crawler.Save(item)
+					sleep(exitCh, 20*time.Second)
 				}
-			} else {
-				glog.Error("No crawler named: ", name)
+				continue
+			}
+			if task == nil {
+				// stopped
+				release()
+				self.Schduler.Done(name)
+				return
+			}
+			// c is not held while downloading, so closing it does not wait
+			// for politeness, downloads and logins
+			release()
+			glog.Info("process task:", task)
+			tasks, items, err := c.Process(&task.Task)
+			if release, ok = self.Crawlers.Hold(c); !ok {
+				// closed or updated meanwhile, the task is retried from the
+				// running store
+				self.setRunning(worker, nil)
+				self.Schduler.Done(name)
+				continue
 			}
+			self.finishTask(worker, c, task, tasks, items, err)
+			release()
+			self.Schduler.Done(name)
 		}
 	}
 }
 
-func (self *Controller) stop(sigs chan os.Signal, exitCh chan int) {
-	if !self.isInited {
-		glog.Error(ErrNotInited)
+// enqueue puts task to the queue of crawler c and wakes up idle workers
+func (self *Controller) enqueue(c *crawler.Crawler, task types.Task) error {
+	if _, err := c.TaskQueue.EnqueueObject(task); err != nil {
+		return err
+	}
+	self.Schduler.Notify(c.Conf.CrawlerName)
+	return nil
+}
+
+// idle stops scheduling crawler c until tasks are enqueued for it, it reports
+// whether the queue of c is empty
+func (self *Controller) idle(c *crawler.Crawler) bool {
+	empty := false
+	self.Schduler.Idle(c.Conf.CrawlerName, func() bool {
+		empty = self.isRunning(c) && c.TaskQueue.Length() == 0
+		return empty
+	})
+	return empty
+}
+
+// dequeue takes the next task of crawler c and records it as running
+func (self *Controller) dequeue(worker int, c *crawler.Crawler) (*runningTask, error) {
+	self.closeLock.RLock()
+	defer self.closeLock.RUnlock()
+	if self.stopped {
+		return nil, nil
+	}
+	item, err := c.TaskQueue.Dequeue()
+	if err != nil {
+		return nil, err
+	}
+	task := &runningTask{queue: c.TaskQueue}
+	if err = item.ToObject(&task.Task); err != nil {
+		return nil, err
+	}
+	task.key = timeStr(time.Now().Unix()+300) + "\t" + task.Id()
+	value, _ := store.ObjectToBytes(task.Task)
+	self.Stores["running"].Put(task.key, value)
+	self.setRunning(worker, task)
+	return task, nil
+}
+
+// finishTask records the result of a processed task, it is dropped if the
+// controller is stopped, since the task was returned to its queue
+func (self *Controller) finishTask(worker int, c *crawler.Crawler, rt *runningTask,
+	tasks []types.Task, items []map[string]interface{}, err error) {
+	self.closeLock.RLock()
+	defer self.closeLock.RUnlock()
+	if self.stopped {
+		return
+	}
+	self.setRunning(worker, nil)
+	task := rt.Task
+	// remove task from Running
+	self.Stores["running"].Delete(rt.key)
+	if err == crawler.ErrDisallowed {
+		// record the task instead of retrying it
+		value, _ := store.ObjectToBytes(task)
+		self.Stores["disallowed"].Put(task.Id(), value)
+		return
+	}
+	if err == crawler.ErrHostBusy {
+		// not a failure, the worker is free for other hosts meanwhile
+		self.postpone(task, hostBusyDelay)
+		return
+	}
+	if err != nil {
+		glog.Error(err)
+		self.taskFailed(c, task, err)
 		return
 	}
-	<-sigs
-	glog.Info("receive stop signal")
-	close(exitCh)
+
+	now := time.Now().Unix()
+	if parseConf, ok := c.Conf.ParseConfs[task.ParserName]; ok && task.IsSeedUrl {
+		next, interval := parseConf.NextRevisit(now), parseConf.RevisitInterval
+		if parseConf.AdaptiveRevisit() {
+			interval = self.adaptRevisit(&parseConf, &task, now)
+			next = now + interval
+		}
+		if next > 0 && self.acceptsTasks(task.CrawlerName) {
+			// add this task back to crontab
+			task.LastAccessTime = now
+			task.Attempts, task.LastError = 0, ""
+			task.RevisitInterval = interval
+			task.RevisitCron = parseConf.RevisitSchedule()
+			self.scheduleSeed(task, next)
+		}
+	}
+	for _, t := range tasks {
+		// add SeedUrl to Seed
+		if t.IsSeedUrl {
+			value, _ := store.ObjectToBytes(t)
+			self.Stores["seed"].Put(t.Id(), value)
+		}
+		if !self.shouldEnqueue(c, &t) {
+			continue
+		}
+		glog.Info("enqueue task:", t)
+		if err = self.enqueue(c, t); err == nil {
+			c.Stats.AddTasks(1)
+		}
+	}
+	c.Stats.AddItems(len(items))
+	for _, item := range items {
+		if err = c.Save(item); err != nil {
+			glog.Error(err)
+		}
+	}
+}
+
+func (self *Controller) stop(sigs chan os.Signal) {
+	select {
+	case <-sigs:
+		glog.Info("receive stop signal")
+		self.Stop()
+	case <-self.exitCh:
+	}
 }
 
 func (self *Controller) Run() {
@@ -385,8 +589,8 @@ func (self *Controller) Run() {
 		glog.Error(ErrNotInited)
 		return
 	}
-	exitCh := make(chan int)
-	sigs := make(chan os.Signal)
+	exitCh := self.exitCh
+	sigs := make(chan os.Signal, 1)
 	var wg sync.WaitGroup
 	for i := 0; i < self.WorkerCount; i++ {
 		wg.Add(1)
@@ -396,7 +600,10 @@ func (self *Controller) Run() {
 	go self.cron(&wg, exitCh)
 	wg.Add(1)
 	go self.retry(&wg, exitCh)
+	wg.Add(1)
+	go self.statsLoop(&wg, exitCh)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go self.stop(sigs, exitCh)
-	wg.Wait()
+	defer signal.Stop(sigs)
+	go self.stop(sigs)
+	self.wait(&wg)
 }
@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"github.com/crawlerclub/x/crawler"
+	"github.com/crawlerclub/x/types"
+	"github.com/liuzl/store"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunCrawlerSinkError(t *testing.T) {
+	ctl, cleanup := newTestController(t)
+	defer cleanup()
+	// a jsonl sink can not be made in a file
+	file := filepath.Join(ctl.workDir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	item := &types.CrawlerItem{
+		CrawlerName: "test",
+		Weight:      1,
+		Conf: types.CrawlerConf{
+			CrawlerType:     "navigation",
+			CrawlerName:     "test",
+			StartUrls:       []string{"http://example.com/"},
+			StartParserName: "page",
+			ParseConfs:      map[string]types.ParseConf{"page": {ParserName: "page"}},
+			Sinks: []types.SinkConf{
+				{SinkType: "jsonl", Uri: filepath.Join(ctl.workDir, "items")},
+				{SinkType: "jsonl", Uri: filepath.Join(file, "items")},
+			},
+		},
+	}
+	if err := ctl.runCrawler(item); err == nil {
+		t.Error("crawler run with a broken sink")
+	}
+	if state := ctl.Crawlers.State("test"); state != "" {
+		t.Errorf("got state %s, want not running", state)
+	}
+}
+
+func TestCloseCrawlerDuringDownload(t *testing.T) {
+	started, unblock := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			close(started)
+			<-unblock
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	ctl, cleanup := newTestController(t)
+	defer cleanup()
+	item := &types.CrawlerItem{
+		CrawlerName: "test",
+		Weight:      1,
+		Conf: types.CrawlerConf{
+			CrawlerType:     "navigation",
+			CrawlerName:     "test",
+			StartUrls:       []string{server.URL + "/page"},
+			StartParserName: "page",
+			ParseConfs:      map[string]types.ParseConf{"page": {ParserName: "page", ParserType: "html"}},
+		},
+	}
+	if err := ctl.runCrawler(item); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	exitCh := make(chan int)
+	wg.Add(1)
+	go ctl.startWorker(0, &wg, exitCh)
+	defer func() {
+		close(unblock)
+		close(exitCh)
+		wg.Wait()
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("task not downloaded")
+	}
+	closed := make(chan struct{})
+	go func() {
+		ctl.CloseCrawler("test")
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("CloseCrawler waits for the download")
+	}
+}
+
+func TestTaskFailed(t *testing.T) {
+	ctl, cleanup := newTestController(t)
+	defer cleanup()
+	c := newUrlSetCrawler(t, ctl, writeUrlsFile(t, ctl.workDir, 0))
+	count := func(name string) int {
+		n := 0
+		ctl.Stores[name].ForEach(nil, func(key, value []byte) (bool, error) {
+			n++
+			return true, nil
+		})
+		return n
+	}
+	task := types.Task{CrawlerName: "urls", ParserName: "page", Url: "http://example.com/a"}
+	ctl.taskFailed(c, task, &crawler.StatusError{Url: task.Url, StatusCode: 503})
+	if count("running") != 1 || count("failed") != 0 {
+		t.Error("status 503 is not retried")
+	}
+	task.Url = "http://example.com/b"
+	ctl.taskFailed(c, task, &crawler.StatusError{Url: task.Url, StatusCode: 404})
+	if count("running") != 1 || count("failed") != 1 {
+		t.Error("status 404 is retried")
+	}
+}
+
+func TestResetClearsTasks(t *testing.T) {
+	ctl, cleanup := newTestController(t)
+	defer cleanup()
+	item := &types.CrawlerItem{CrawlerName: "test", Status: "disabled"}
+	value, _ := store.ObjectToBytes(item)
+	ctl.Stores["crawler"].Put("test", value)
+	for _, name := range []string{"test", "other"} {
+		task := types.Task{CrawlerName: name, Url: "http://example.com/"}
+		value, _ = store.ObjectToBytes(task)
+		ctl.Stores["running"].Put(timeStr(time.Now().Unix())+"\t"+task.Id(), value)
+		ctl.Stores["failed"].Put(task.Id(), value)
+		ctl.Stores["disallowed"].Put(task.Id(), value)
+	}
+	if err := ctl.Reset("test"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"running", "failed", "disallowed"} {
+		ctl.Stores[s].ForEach(nil, func(key, value []byte) (bool, error) {
+			var task types.Task
+			store.BytesToObject(value, &task)
+			if task.CrawlerName != "other" {
+				t.Errorf("%s of %s is kept in %s", key, task.CrawlerName, s)
+			}
+			return true, nil
+		})
+	}
+}
+
+func TestDiscoverSitemaps(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+			<url><loc>http://example.com/a</loc><lastmod>2018-01-01</lastmod></url>
+			<url><loc>http://example.com/b</loc></url></urlset>`))
+	}))
+	defer server.Close()
+	ctl, cleanup := newTestController(t)
+	defer cleanup()
+	c := newUrlSetCrawler(t, ctl, writeUrlsFile(t, ctl.workDir, 0))
+	c.Conf.Sitemap.SitemapUrls = []string{server.URL + "/sitemap.xml"}
+
+	// urls without lastmod are enqueued on each discovery
+	for i, want := range []int{2, 3} {
+		ctl.discoverSitemaps(c)
+		if n := c.TaskQueue.Length(); int(n) != want {
+			t.Errorf("discovery %d: got %d tasks, want %d", i, n, want)
+		}
+	}
+
+	c.Conf.Sitemap.RevisitInterval = 3600
+	done := make(chan struct{})
+	go func() {
+		ctl.discoverSitemaps(c)
+		close(done)
+	}()
+	ctl.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("discovery not stopped")
+	}
+}
+
+func TestDelCrawler(t *testing.T) {
+	ctl, cleanup := newTestController(t)
+	defer cleanup()
+	for _, name := range []string{"test", "other"} {
+		ctl.Stores["crawler"].Put(name, []byte("{}"))
+		ctl.Stores["fingerprint"].Put(name+"\thttp://example.com/", []byte("{}"))
+		ctl.Stores["session"].Put(name, []byte("[]"))
+	}
+	if err := ctl.DelCrawler("test"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"crawler", "fingerprint", "session"} {
+		var keys []string
+		ctl.Stores[s].ForEach(nil, func(key, value []byte) (bool, error) {
+			keys = append(keys, string(key))
+			return true, nil
+		})
+		if len(keys) != 1 || !strings.HasPrefix(keys[0], "other") {
+			t.Errorf("got keys %q of %s", keys, s)
+		}
+	}
+}
+
+func TestSeedsNextRun(t *testing.T) {
+	ctl, cleanup := newTestController(t)
+	defer cleanup()
+	value, _ := store.ObjectToBytes(&types.CrawlerItem{CrawlerName: "test"})
+	ctl.Stores["crawler"].Put("test", value)
+	task := types.Task{CrawlerName: "test", Url: "http://example.com/", IsSeedUrl: true}
+	value, _ = store.ObjectToBytes(task)
+	ctl.Stores["seed"].Put(task.Id(), value)
+	nextRun := func() int64 {
+		seeds, err := ctl.Seeds("test")
+		if err != nil || len(seeds) != 1 {
+			t.Fatal(seeds, err)
+		}
+		return seeds[0].NextRun
+	}
+
+	next := time.Now().Unix() + 3600
+	ctl.scheduleSeed(task, next)
+	if n := nextRun(); n != next {
+		t.Errorf("got next run %d, want %d", n, next)
+	}
+	// indexed again from crontab
+	ctl.Stores["next_run"].Delete(task.Id())
+	if err := ctl.indexNextRuns(); err != nil {
+		t.Fatal(err)
+	}
+	if n := nextRun(); n != next {
+		t.Errorf("got next run %d after indexing, want %d", n, next)
+	}
+	ctl.unscheduleSeed(timeStr(next) + "\t" + task.Id())
+	if n := nextRun(); n != 0 {
+		t.Errorf("got next run %d after it is enqueued", n)
+	}
+}
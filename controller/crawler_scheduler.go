@@ -1,92 +1,336 @@
 package controller
 
 import (
-	"container/list"
 	"errors"
-	"github.com/liuzl/ds"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 )
 
 var (
-	ErrNilList      = errors.New("SortedList is nil")
-	ErrTotalWeight  = errors.New("SortedList totalWeight 0")
-	ErrNeverHappen  = errors.New("weighted random selection error")
-	ErrNameNotFound = errors.New("crawler name not found")
+	ErrNilList        = errors.New("SortedList is nil")
+	ErrTotalWeight    = errors.New("SortedList totalWeight 0")
+	ErrNeverHappen    = errors.New("weighted random selection error")
+	ErrNameNotFound   = errors.New("crawler name not found")
+	ErrNoReadyCrawler = errors.New("no crawler has pending tasks")
+	ErrSchedulerExit  = errors.New("scheduler exit")
+)
+
+const (
+	// PolicyWeighted picks a crawler randomly in proportion to its weight
+	PolicyWeighted = "weighted"
+	// PolicyDRR serves crawlers in deficit round-robin, a crawler takes
+	// weight tasks in a row on each round
+	PolicyDRR = "drr"
 )
 
 type Item struct {
 	CrawlerName string
 	Weight      int
+	// max tasks of the crawler processed at the same time, 0 means no limit
+	MaxConcurrency int
 }
 
-func compareWeight(left interface{}, right interface{}) int {
-	// from big to small
-	return right.(Item).Weight - left.(Item).Weight
+type schedEntry struct {
+	Item
+	// the task queue may have tasks
+	pending bool
+	// shared with the entries of the same crawler inserted again
+	running *int
+	deficit int
 }
 
+// ready reports whether a worker can take a task of the entry now, crawlers
+// of weight 0 are never chosen
+func (self *schedEntry) ready() bool {
+	return self.Weight > 0 && self.pending &&
+		(self.MaxConcurrency <= 0 || *self.running < self.MaxConcurrency)
+}
+
+// CrawlerScheduler chooses the crawler a worker works on next, only crawlers
+// whose queues have pending tasks and are under their max concurrency are
+// chosen. Workers block in Next until a crawler is ready.
 type CrawlerScheduler struct {
 	sync.RWMutex
-	crawlerList *ds.SortedList
+	// PolicyWeighted if empty
+	Policy string
+
+	entries map[string]*schedEntry
+	// tasks being processed by crawler name, kept when it is removed
+	running map[string]*int
+	// in the order of insertion
+	order       []*schedEntry
 	totalWeight int
+	rnd         *rand.Rand
+	// cumulative weights of ready entries, rebuilt when dirty
+	ready  []*schedEntry
+	cumsum []int
+	dirty  bool
+	// closed and replaced when a crawler may become ready
+	wake chan struct{}
+	// current position of drr
+	cursor  int
+	visited bool
 }
 
 func (self *CrawlerScheduler) Init() {
 	self.Lock()
 	defer self.Unlock()
-	if self.crawlerList != nil {
+	if self.entries != nil {
 		return
 	}
 	self.totalWeight = 0
-	self.crawlerList = ds.NewSortedList(compareWeight)
+	self.entries = make(map[string]*schedEntry)
+	self.running = make(map[string]*int)
+	self.wake = make(chan struct{})
+	self.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	self.dirty = true
 }
 
-func (self *CrawlerScheduler) WeightedChoice() (string, error) {
-	if self.crawlerList == nil {
-		return "", ErrNilList
+func (self *CrawlerScheduler) broadcast() {
+	close(self.wake)
+	self.wake = make(chan struct{})
+}
+
+// update applies fn to e, workers are woken up if e becomes ready
+func (self *CrawlerScheduler) update(e *schedEntry, fn func()) {
+	was := e.ready()
+	fn()
+	if now := e.ready(); now != was {
+		self.dirty = true
+		if now {
+			self.broadcast()
+		}
 	}
-	rand.Seed(time.Now().UTC().UnixNano())
+}
+
+// WeightedChoice picks a ready crawler randomly by weight
+func (self *CrawlerScheduler) WeightedChoice() (string, error) {
 	self.Lock()
 	defer self.Unlock()
+	if self.entries == nil {
+		return "", ErrNilList
+	}
+	e, err := self.weightedChoice()
+	if err != nil {
+		return "", err
+	}
+	return e.CrawlerName, nil
+}
+
+func (self *CrawlerScheduler) weightedChoice() (*schedEntry, error) {
 	if self.totalWeight <= 0 {
-		return "", ErrTotalWeight
+		return nil, ErrTotalWeight
 	}
-	rnd := rand.Intn(self.totalWeight)
-	for e := self.crawlerList.Front(); e != nil; e = e.Next() {
-		rnd -= e.Value.(Item).Weight
-		if rnd <= 0 {
-			return e.Value.(Item).CrawlerName, nil
+	if self.dirty {
+		self.ready, self.cumsum = self.ready[:0], self.cumsum[:0]
+		total := 0
+		for _, e := range self.order {
+			if e.ready() {
+				total += e.Weight
+				self.ready = append(self.ready, e)
+				self.cumsum = append(self.cumsum, total)
+			}
 		}
+		self.dirty = false
 	}
-	return "", ErrNeverHappen
+	n := len(self.cumsum)
+	if n == 0 {
+		return nil, ErrNoReadyCrawler
+	}
+	// the i-th ready entry takes [cumsum[i-1], cumsum[i])
+	rnd := self.rnd.Intn(self.cumsum[n-1])
+	i := sort.Search(n, func(i int) bool { return self.cumsum[i] > rnd })
+	if i >= n {
+		return nil, ErrNeverHappen
+	}
+	return self.ready[i], nil
 }
 
+// drrChoice goes on with the crawler at cursor while its deficit lasts, a
+// crawler gets weight more on each visit and loses what is left when its
+// queue runs empty
+func (self *CrawlerScheduler) drrChoice() (*schedEntry, error) {
+	n := len(self.order)
+	for i := 0; i <= n && n > 0; i++ {
+		if self.cursor >= n {
+			self.cursor, self.visited = 0, false
+		}
+		e := self.order[self.cursor]
+		if e.ready() {
+			if !self.visited {
+				e.deficit += e.Weight
+				self.visited = true
+			}
+			if e.deficit >= 1 {
+				e.deficit--
+				return e, nil
+			}
+		} else if !e.pending {
+			e.deficit = 0
+		}
+		self.cursor++
+		self.visited = false
+	}
+	return nil, ErrNoReadyCrawler
+}
+
+// Next blocks until a crawler is ready and returns its name, Done must be
+// called with the name after the task is processed. It returns
+// ErrSchedulerExit when exitCh is closed.
+func (self *CrawlerScheduler) Next(exitCh <-chan int) (string, error) {
+	for {
+		self.Lock()
+		if self.entries == nil {
+			self.Unlock()
+			return "", ErrNilList
+		}
+		var e *schedEntry
+		var err error
+		if self.Policy == PolicyDRR {
+			e, err = self.drrChoice()
+		} else {
+			e, err = self.weightedChoice()
+		}
+		if err == nil {
+			self.update(e, func() { *e.running++ })
+			self.Unlock()
+			return e.CrawlerName, nil
+		}
+		wake := self.wake
+		self.Unlock()
+		select {
+		case <-exitCh:
+			return "", ErrSchedulerExit
+		case <-wake:
+		}
+	}
+}
+
+// Done marks a task of crawler name taken by Next as processed
+func (self *CrawlerScheduler) Done(name string) {
+	self.Lock()
+	defer self.Unlock()
+	running, ok := self.running[name]
+	if !ok || *running <= 0 {
+		return
+	}
+	e, ok := self.entries[name]
+	if ok {
+		self.update(e, func() { *running-- })
+	} else {
+		*running--
+	}
+	if *running == 0 && !ok {
+		delete(self.running, name)
+	}
+}
+
+// Notify tells the scheduler that tasks are enqueued for crawler name
+func (self *CrawlerScheduler) Notify(name string) {
+	self.Lock()
+	defer self.Unlock()
+	if e, ok := self.entries[name]; ok && !e.pending {
+		self.update(e, func() { e.pending = true })
+	}
+}
+
+// Idle marks crawler name as having no pending tasks if empty still reports
+// so, empty is called with the lock held so that a Notify after the check is
+// not lost
+func (self *CrawlerScheduler) Idle(name string, empty func() bool) {
+	self.Lock()
+	defer self.Unlock()
+	if e, ok := self.entries[name]; ok && empty() {
+		self.update(e, func() { e.pending = false })
+	}
+}
+
+// Insert adds a crawler, it is taken as having pending tasks until a worker
+// finds its queue empty. Crawlers of weight 0 are not added.
 func (self *CrawlerScheduler) Insert(item Item) error {
 	self.Lock()
 	defer self.Unlock()
-	if self.crawlerList == nil {
+	if self.entries == nil {
 		return ErrNilList
 	}
-	self.crawlerList.Insert(item)
+	if old, ok := self.entries[item.CrawlerName]; ok {
+		self.remove(old)
+	}
+	if item.Weight <= 0 {
+		return nil
+	}
+	running, ok := self.running[item.CrawlerName]
+	if !ok {
+		running = new(int)
+		self.running[item.CrawlerName] = running
+	}
+	e := &schedEntry{Item: item, pending: true, running: running}
+	self.entries[item.CrawlerName] = e
+	self.order = append(self.order, e)
 	self.totalWeight += item.Weight
+	self.dirty = true
+	self.broadcast()
+	return nil
+}
+
+// SetWeight changes the weight of crawler name in place, a crawler of weight
+// 0 is kept but not chosen
+func (self *CrawlerScheduler) SetWeight(name string, weight int) error {
+	self.Lock()
+	defer self.Unlock()
+	if self.entries == nil {
+		return ErrNilList
+	}
+	e, ok := self.entries[name]
+	if !ok {
+		return ErrNameNotFound
+	}
+	if weight < 0 {
+		weight = 0
+	}
+	self.totalWeight += weight - e.Weight
+	self.update(e, func() { e.Weight = weight })
+	// cumulative weights are changed even if e is still ready
+	self.dirty = true
+	if e.deficit > weight {
+		e.deficit = weight
+	}
 	return nil
 }
 
 func (self *CrawlerScheduler) Remove(name string) error {
 	self.Lock()
 	defer self.Unlock()
-	if self.crawlerList == nil {
+	if self.entries == nil {
 		return ErrNilList
 	}
-	var next *list.Element
-	for e := self.crawlerList.Front(); e != nil; e = next {
-		next = e.Next()
-		if e.Value.(Item).CrawlerName == name {
-			self.crawlerList.Remove(e)
-			self.totalWeight -= e.Value.(Item).Weight
-			return nil
+	e, ok := self.entries[name]
+	if !ok {
+		return ErrNameNotFound
+	}
+	self.remove(e)
+	return nil
+}
+
+func (self *CrawlerScheduler) remove(e *schedEntry) {
+	for i, o := range self.order {
+		if o != e {
+			continue
 		}
+		self.order = append(self.order[:i], self.order[i+1:]...)
+		if i < self.cursor {
+			self.cursor--
+		} else if i == self.cursor {
+			self.visited = false
+		}
+		break
+	}
+	delete(self.entries, e.CrawlerName)
+	self.dirty = true
+	if *e.running == 0 {
+		delete(self.running, e.CrawlerName)
 	}
-	return ErrNameNotFound
+	self.totalWeight -= e.Weight
 }
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(policy string, items ...Item) *CrawlerScheduler {
+	s := &CrawlerScheduler{Policy: policy}
+	s.Init()
+	for _, item := range items {
+		s.Insert(item)
+	}
+	return s
+}
+
+func TestSchedulerSkipsIdle(t *testing.T) {
+	s := newTestScheduler(PolicyWeighted,
+		Item{CrawlerName: "heavy", Weight: 100}, Item{CrawlerName: "light", Weight: 1})
+	s.Idle("heavy", func() bool { return true })
+	exitCh := make(chan int)
+	for i := 0; i < 100; i++ {
+		name, err := s.Next(exitCh)
+		if err != nil || name != "light" {
+			t.Fatalf("got %s, %v, want light", name, err)
+		}
+		s.Done(name)
+	}
+	s.Idle("light", func() bool { return false })
+	s.Idle("light", func() bool { return true })
+
+	got := make(chan string)
+	go func() {
+		name, _ := s.Next(exitCh)
+		got <- name
+	}()
+	select {
+	case name := <-got:
+		t.Fatal("no crawler is pending, got ", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.Notify("heavy")
+	select {
+	case name := <-got:
+		if name != "heavy" {
+			t.Errorf("got %s, want heavy", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not woken up by Notify")
+	}
+
+	s.Idle("heavy", func() bool { return true })
+	go func() {
+		_, err := s.Next(exitCh)
+		if err != ErrSchedulerExit {
+			t.Error("got ", err, ", want ErrSchedulerExit")
+		}
+		got <- ""
+	}()
+	close(exitCh)
+	<-got
+}
+
+func TestSchedulerMaxConcurrency(t *testing.T) {
+	s := newTestScheduler(PolicyWeighted,
+		Item{CrawlerName: "a", Weight: 100, MaxConcurrency: 2}, Item{CrawlerName: "b", Weight: 1})
+	exitCh := make(chan int)
+	counts := map[string]int{}
+	for i := 0; i < 10; i++ {
+		name, _ := s.Next(exitCh)
+		counts[name]++
+	}
+	if counts["a"] != 2 || counts["b"] != 8 {
+		t.Errorf("got %v, want 2 a and 8 b", counts)
+	}
+	// kept when a crawler is paused and resumed
+	s.Remove("a")
+	s.Insert(Item{CrawlerName: "a", Weight: 100, MaxConcurrency: 2})
+	s.Idle("b", func() bool { return true })
+	s.Done("a")
+	name, _ := s.Next(exitCh)
+	if name != "a" {
+		t.Errorf("got %s, want a", name)
+	}
+}
+
+func TestSchedulerDRR(t *testing.T) {
+	s := newTestScheduler(PolicyDRR,
+		Item{CrawlerName: "a", Weight: 3}, Item{CrawlerName: "b", Weight: 1},
+		Item{CrawlerName: "c", Weight: 2})
+	exitCh := make(chan int)
+	var seq string
+	for i := 0; i < 12; i++ {
+		name, _ := s.Next(exitCh)
+		s.Done(name)
+		seq += name
+	}
+	if seq != "aaabccaaabcc" {
+		t.Errorf("got %s, want aaabccaaabcc", seq)
+	}
+	s.Idle("a", func() bool { return true })
+	seq = ""
+	for i := 0; i < 6; i++ {
+		name, _ := s.Next(exitCh)
+		s.Done(name)
+		seq += name
+	}
+	if seq != "bccbcc" {
+		t.Errorf("got %s, want bccbcc", seq)
+	}
+}
+
+func TestSchedulerDistribution(t *testing.T) {
+	weights := map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "zero": 0}
+	s := newTestScheduler(PolicyWeighted)
+	s.rnd = rand.New(rand.NewSource(1))
+	total := 0
+	for _, name := range []string{"a", "b", "c", "d", "zero"} {
+		s.Insert(Item{CrawlerName: name, Weight: weights[name]})
+		total += weights[name]
+	}
+	check := func() {
+		const n = 100000
+		counts := map[string]int{}
+		for i := 0; i < n; i++ {
+			name, err := s.WeightedChoice()
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts[name]++
+		}
+		// critical chi-square of p = 0.001 with 3 degrees of freedom
+		chi2 := 0.0
+		for name, w := range weights {
+			expected := float64(n) * float64(w) / float64(total)
+			if w == 0 {
+				if counts[name] > 0 {
+					t.Errorf("%s of weight 0 chosen %d times", name, counts[name])
+				}
+				continue
+			}
+			d := float64(counts[name]) - expected
+			chi2 += d * d / expected
+		}
+		if chi2 > 16.27 {
+			t.Errorf("distribution %v does not match weights %v, chi2 %.2f", counts, weights, chi2)
+		}
+	}
+	check()
+
+	// changed at runtime
+	weights["a"], weights["d"] = 4, 1
+	s.SetWeight("a", 4)
+	s.SetWeight("d", 1)
+	check()
+
+	weights["a"] = 0
+	total -= 4
+	s.SetWeight("a", 0)
+	check()
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"github.com/crawlerclub/x/crawler"
+	"github.com/crawlerclub/x/parser"
+	"github.com/crawlerclub/x/types"
+	"github.com/golang/glog"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultBloomCapacity = 1000000
+	bloomFalsePositive   = 0.001
+)
+
+// seenKey returns the key of url in the seen store, "" if url is invalid
+func seenKey(conf *types.CrawlerConf, url string) string {
+	canonical, err := parser.CanonicalUrl(url, conf.Dedup.IgnoreParams)
+	if err != nil {
+		return ""
+	}
+	return conf.CrawlerName + "\t" + canonical
+}
+
+// loadBloomFilter fills the bloom filter of crawler conf with its seen urls
+func (self *Controller) loadBloomFilter(conf *types.CrawlerConf) {
+	self.bloomLock.Lock()
+	delete(self.blooms, conf.CrawlerName)
+	self.bloomLock.Unlock()
+	if conf.Dedup.Disabled || !conf.Dedup.BloomFilter {
+		return
+	}
+	capacity := conf.Dedup.BloomCapacity
+	if capacity <= 0 {
+		capacity = defaultBloomCapacity
+	}
+	filter := newBloomFilter(capacity, bloomFalsePositive)
+	count := 0
+	prefix := util.BytesPrefix([]byte(conf.CrawlerName + "\t"))
+	err := self.Stores["seen"].ForEach(prefix, func(key, value []byte) (bool, error) {
+		filter.Add(string(key))
+		count++
+		return true, nil
+	})
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	glog.Info(conf.CrawlerName, " bloom filter loaded ", count, " seen urls")
+	self.bloomLock.Lock()
+	self.blooms[conf.CrawlerName] = filter
+	self.bloomLock.Unlock()
+}
+
+func (self *Controller) bloomFilter(name string) *bloomFilter {
+	self.bloomLock.Lock()
+	defer self.bloomLock.Unlock()
+	return self.blooms[name]
+}
+
+// lastSeen returns the unix time url was enqueued last time, 0 if never
+func (self *Controller) lastSeen(name, key string) int64 {
+	if filter := self.bloomFilter(name); filter != nil && !filter.Test(key) {
+		return 0
+	}
+	value, err := self.Stores["seen"].Get(key)
+	if err != nil {
+		return 0
+	}
+	t, _ := strconv.ParseInt(string(value), 10, 64)
+	return t
+}
+
+func (self *Controller) markSeen(name, key string, now int64) {
+	if err := self.Stores["seen"].Put(key, []byte(strconv.FormatInt(now, 10))); err != nil {
+		glog.Error(err)
+		return
+	}
+	if filter := self.bloomFilter(name); filter != nil {
+		filter.Add(key)
+	}
+}
+
+// shouldEnqueue checks task against the seen urls of crawler c and marks it
+// seen if it is new. Seeds are enqueued again once their revisit interval
+// has passed or revisit_cron has fired since last time.
+func (self *Controller) shouldEnqueue(c *crawler.Crawler, task *types.Task) bool {
+	conf := c.Conf
+	if !self.acceptsTasks(conf.CrawlerName) {
+		return false
+	}
+	if conf.Dedup.Disabled {
+		return true
+	}
+	key := seenKey(conf, task.Url)
+	if key == "" {
+		return true
+	}
+	if body := task.GetData().Body; body != "" {
+		// e.g. pages of a search form posted to the same url
+		key += "\t" + types.BodyHash(body)
+	}
+	now := time.Now().Unix()
+	if last := self.lastSeen(conf.CrawlerName, key); last > 0 {
+		if !task.IsSeedUrl {
+			return false
+		}
+		p, ok := conf.ParseConfs[task.ParserName]
+		if !ok {
+			return false
+		}
+		if next := self.nextRevisit(&p, task, last); next <= 0 || now < next {
+			return false
+		}
+	}
+	self.markSeen(conf.CrawlerName, key, now)
+	return true
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queueDepthDesc = prometheus.NewDesc("crawler_queue_depth",
+		"Tasks in the queues of running crawlers.", []string{"crawler"}, nil)
+	storeSizeDesc = prometheus.NewDesc("crawler_store_size",
+		"Tasks in the running, crontab and failed stores.", []string{"store"}, nil)
+)
+
+var metricStores = []string{"running", "crontab", "failed"}
+
+// countStores counts the tasks of metricStores for scraping, which is too
+// slow to do on each scrape. It must be called with closeLock held.
+func (self *Controller) countStores() {
+	sizes := make(map[string]int, len(metricStores))
+	for _, name := range metricStores {
+		count := 0
+		self.Stores[name].ForEach(nil, func(key, value []byte) (bool, error) {
+			count++
+			return true, nil
+		})
+		sizes[name] = count
+	}
+	self.sizesLock.Lock()
+	self.storeSizes = sizes
+	self.sizesLock.Unlock()
+}
+
+// collector reports the queue depth and store sizes on scraping, the sizes
+// are the ones counted by statsLoop last time
+type collector struct {
+	ctl *Controller
+}
+
+func (self *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- storeSizeDesc
+}
+
+func (self *collector) Collect(ch chan<- prometheus.Metric) {
+	ctl := self.ctl
+	ctl.closeLock.RLock()
+	defer ctl.closeLock.RUnlock()
+	if ctl.stopped {
+		return
+	}
+	for _, name := range ctl.Crawlers.Names() {
+		if c, release, ok := ctl.Crawlers.Acquire(name); ok {
+			ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue,
+				float64(c.TaskQueue.Length()), name)
+			release()
+		}
+	}
+	ctl.sizesLock.Lock()
+	defer ctl.sizesLock.Unlock()
+	for name, count := range ctl.storeSizes {
+		ch <- prometheus.MustNewConstMetric(storeSizeDesc, prometheus.GaugeValue,
+			float64(count), name)
+	}
+}
+
+// RegisterMetrics registers the collector of queue depth and store sizes to
+// the default prometheus registry
+func (self *Controller) RegisterMetrics() error {
+	return prometheus.Register(&collector{ctl: self})
+}
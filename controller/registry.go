@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"errors"
+	"github.com/crawlerclub/x/crawler"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrCrawlerNotRunning = errors.New("controller/registry.go crawler is not running")
+)
+
+type registryEntry struct {
+	crawler *crawler.Crawler
+	refs    int
+	removed bool // closed by the last release
+	done    chan struct{}
+	state   string
+}
+
+// CrawlerRegistry holds the running crawlers. A crawler is acquired by
+// workers before using its queue, and it is closed only after it is removed
+// and released by all of them, so a closed queue is never used.
+type CrawlerRegistry struct {
+	sync.Mutex
+	entries map[string]*registryEntry
+	closeFn func(c *crawler.Crawler)
+}
+
+func NewCrawlerRegistry() *CrawlerRegistry {
+	return &CrawlerRegistry{
+		entries: make(map[string]*registryEntry),
+		closeFn: (*crawler.Crawler).Close,
+	}
+}
+
+// Put registers c, the crawler with the same name is removed
+func (self *CrawlerRegistry) Put(c *crawler.Crawler) {
+	self.Lock()
+	defer self.Unlock()
+	name := c.Conf.CrawlerName
+	if old, ok := self.entries[name]; ok {
+		self.remove(old)
+	}
+	self.entries[name] = &registryEntry{crawler: c, done: make(chan struct{}), state: StateRunning}
+}
+
+// Remove unregisters the crawler of name, the returned chan is closed when
+// the crawler is closed. It returns false if not found.
+func (self *CrawlerRegistry) Remove(name string) (<-chan struct{}, bool) {
+	self.Lock()
+	defer self.Unlock()
+	entry, ok := self.entries[name]
+	if !ok {
+		return nil, false
+	}
+	delete(self.entries, name)
+	self.remove(entry)
+	return entry.done, true
+}
+
+// remove marks entry removed and closes it if nobody holds it, must be
+// called with lock held
+func (self *CrawlerRegistry) remove(entry *registryEntry) {
+	entry.removed = true
+	if entry.refs == 0 {
+		self.close(entry)
+	}
+}
+
+// Acquire returns the crawler of name, which stays open until the returned
+// release func is called
+func (self *CrawlerRegistry) Acquire(name string) (*crawler.Crawler, func(), bool) {
+	self.Lock()
+	defer self.Unlock()
+	entry, ok := self.entries[name]
+	if !ok {
+		return nil, nil, false
+	}
+	return entry.crawler, self.acquire(entry), true
+}
+
+// Hold acquires c if it is still the registered crawler of its name
+func (self *CrawlerRegistry) Hold(c *crawler.Crawler) (func(), bool) {
+	self.Lock()
+	defer self.Unlock()
+	entry, ok := self.entries[c.Conf.CrawlerName]
+	if !ok || entry.crawler != c {
+		return nil, false
+	}
+	return self.acquire(entry), true
+}
+
+func (self *CrawlerRegistry) acquire(entry *registryEntry) func() {
+	entry.refs++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			self.Lock()
+			defer self.Unlock()
+			entry.refs--
+			if entry.refs == 0 && entry.removed {
+				self.close(entry)
+			}
+		})
+	}
+}
+
+func (self *CrawlerRegistry) close(entry *registryEntry) {
+	self.closeFn(entry.crawler)
+	close(entry.done)
+}
+
+// Has reports whether c is the registered crawler of its name
+func (self *CrawlerRegistry) Has(c *crawler.Crawler) bool {
+	self.Lock()
+	defer self.Unlock()
+	entry, ok := self.entries[c.Conf.CrawlerName]
+	return ok && entry.crawler == c
+}
+
+// SetState sets the state of crawler name, it returns false if not found
+func (self *CrawlerRegistry) SetState(name, state string) bool {
+	self.Lock()
+	defer self.Unlock()
+	entry, ok := self.entries[name]
+	if ok {
+		entry.state = state
+	}
+	return ok
+}
+
+// State returns the state of crawler name, "" if not found
+func (self *CrawlerRegistry) State(name string) string {
+	self.Lock()
+	defer self.Unlock()
+	if entry, ok := self.entries[name]; ok {
+		return entry.state
+	}
+	return ""
+}
+
+// Names returns the sorted names of registered crawlers
+func (self *CrawlerRegistry) Names() []string {
+	self.Lock()
+	defer self.Unlock()
+	names := make([]string, 0, len(self.entries))
+	for name, _ := range self.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
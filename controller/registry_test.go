@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"github.com/crawlerclub/x/crawler"
+	"github.com/crawlerclub/x/types"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestRegistry(closed *sync.Map) *CrawlerRegistry {
+	r := NewCrawlerRegistry()
+	r.closeFn = func(c *crawler.Crawler) {
+		if _, loaded := closed.LoadOrStore(c, true); loaded {
+			panic("crawler closed twice")
+		}
+	}
+	return r
+}
+
+func TestCrawlerRegistry(t *testing.T) {
+	var closed sync.Map
+	r := newTestRegistry(&closed)
+	c := &crawler.Crawler{Conf: &types.CrawlerConf{CrawlerName: "test"}}
+	r.Put(c)
+	got, release, ok := r.Acquire("test")
+	if !ok || got != c {
+		t.Fatal("acquire failed")
+	}
+	done, ok := r.Remove("test")
+	if !ok {
+		t.Fatal("remove failed")
+	}
+	if _, isClosed := closed.Load(c); isClosed {
+		t.Error("crawler closed while acquired")
+	}
+	if _, _, ok = r.Acquire("test"); ok {
+		t.Error("acquired a removed crawler")
+	}
+	release()
+	release() // no effect
+	<-done
+	if _, isClosed := closed.Load(c); !isClosed {
+		t.Error("crawler not closed after release")
+	}
+	if _, ok = r.Hold(c); ok {
+		t.Error("held a removed crawler")
+	}
+}
+
+// run with go test -race
+func TestCrawlerRegistryConcurrent(t *testing.T) {
+	var closed sync.Map
+	r := newTestRegistry(&closed)
+	names := []string{"a", "b", "c"}
+	var wg sync.WaitGroup
+	var stop int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; atomic.LoadInt32(&stop) == 0; j++ {
+				c, release, ok := r.Acquire(names[(i+j)%len(names)])
+				if !ok {
+					continue
+				}
+				if _, isClosed := closed.Load(c); isClosed {
+					t.Error("acquired a closed crawler")
+				}
+				release()
+			}
+		}(i)
+	}
+	var all []*crawler.Crawler
+	for i := 0; i < 300; i++ {
+		name := names[i%len(names)]
+		c := &crawler.Crawler{Conf: &types.CrawlerConf{CrawlerName: name}}
+		all = append(all, c)
+		if i%2 == 0 {
+			r.Put(c)
+		} else {
+			r.Remove(name)
+		}
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	for _, name := range r.Names() {
+		r.Remove(name)
+	}
+	for i := 0; i < len(all); i += 2 {
+		if _, isClosed := closed.Load(all[i]); !isClosed {
+			t.Error("crawler not closed: ", i)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"github.com/crawlerclub/x/crawler"
+	"github.com/crawlerclub/x/metrics"
+	"github.com/crawlerclub/x/types"
+	"github.com/golang/glog"
+	"github.com/liuzl/store"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"time"
+)
+
+// taskFailed schedules a retry of task by the retry policy of its parser, or
+// moves it to the failed store if it has failed too many times or err is
+// permanent
+func (self *Controller) taskFailed(c *crawler.Crawler, task types.Task, err error) {
+	task.Attempts++
+	task.LastError = err.Error()
+	policy := c.Conf.ParseConfs[task.ParserName].Retry
+	value, e := store.ObjectToBytes(task)
+	if e != nil {
+		glog.Error(e)
+		return
+	}
+	if crawler.Permanent(err) || !policy.ShouldRetry(task.Attempts) {
+		metrics.TaskFailures.WithLabelValues(task.CrawlerName).Inc()
+		glog.Error("task failed ", task.Attempts, " times, give up: ", task.Url)
+		if e = self.Stores["failed"].Put(task.Id(), value); e != nil {
+			glog.Error(e)
+		}
+		return
+	}
+	metrics.TaskRetries.WithLabelValues(task.CrawlerName).Inc()
+	// the retry loop enqueues the task when it is due
+	key := timeStr(time.Now().Unix()+policy.Delay(task.Attempts)) + "\t" + task.Id()
+	if e = self.Stores["running"].Put(key, value); e != nil {
+		glog.Error(e)
+	}
+}
+
+// seconds to postpone a task of a busy host
+const hostBusyDelay = 30
+
+// postpone puts task back to the running store, the retry loop enqueues it
+// after delay seconds, its attempts are not counted
+func (self *Controller) postpone(task types.Task, delay int64) {
+	value, err := store.ObjectToBytes(task)
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	key := timeStr(time.Now().Unix()+delay) + "\t" + task.Id()
+	if err = self.Stores["running"].Put(key, value); err != nil {
+		glog.Error(err)
+	}
+}
+
+// RequeueFailed enqueues the failed tasks of crawler name again with their
+// attempts reset, only the task of url is requeued if url is not empty
+func (self *Controller) RequeueFailed(name, url string) (int, error) {
+	c, release, ok := self.Crawlers.Acquire(name)
+	if !ok {
+		return 0, ErrCrawlerNotRunning
+	}
+	defer release()
+	prefix := name + "\t"
+	if url != "" {
+		prefix += url
+	}
+	count := 0
+	err := self.Stores["failed"].ForEach(util.BytesPrefix([]byte(prefix)),
+		func(key, value []byte) (bool, error) {
+			var task types.Task
+			if e := store.BytesToObject(value, &task); e != nil {
+				return false, e
+			}
+			if url != "" && task.Url != url {
+				return true, nil
+			}
+			task.Attempts = 0
+			task.LastError = ""
+			if e := self.enqueue(c, task); e != nil {
+				return false, e
+			}
+			count++
+			return true, self.Stores["failed"].Delete(string(key))
+		})
+	return count, err
+}
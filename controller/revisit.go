@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/crawlerclub/x/types"
+	"github.com/golang/glog"
+)
+
+// revisitState is kept in the fingerprint store by seed id for adaptive
+// revisits
+type revisitState struct {
+	Fingerprint string `json:"fingerprint"`
+	// current revisit interval in seconds
+	Interval int64 `json:"interval"`
+	// unix time the page was found changed last time
+	Changed int64 `json:"changed"`
+}
+
+func (self *Controller) revisitState(id string) *revisitState {
+	b, err := self.Stores["fingerprint"].Get(id)
+	if err != nil {
+		return nil
+	}
+	var state revisitState
+	if err = json.Unmarshal(b, &state); err != nil {
+		glog.Error(err)
+		return nil
+	}
+	return &state
+}
+
+// adaptRevisit compares the fingerprint of seed task visited at now with the
+// one of last visit and returns the adapted revisit interval
+func (self *Controller) adaptRevisit(p *types.ParseConf, task *types.Task, now int64) int64 {
+	state := self.revisitState(task.Id())
+	if state == nil {
+		state = &revisitState{Fingerprint: task.Fingerprint, Changed: now,
+			Interval: p.AdaptRevisitInterval(0, false)}
+	} else {
+		changed := task.Fingerprint != state.Fingerprint
+		state.Interval = p.AdaptRevisitInterval(state.Interval, changed)
+		if changed {
+			state.Fingerprint, state.Changed = task.Fingerprint, now
+		}
+	}
+	if b, err := json.Marshal(state); err != nil {
+		glog.Error(err)
+	} else if err = self.Stores["fingerprint"].Put(task.Id(), b); err != nil {
+		glog.Error(err)
+	}
+	return state.Interval
+}
+
+// nextRevisit returns the unix time to crawl seed task visited at last again,
+// 0 if it is not revisited
+func (self *Controller) nextRevisit(p *types.ParseConf, task *types.Task, last int64) int64 {
+	if !p.AdaptiveRevisit() {
+		return p.NextRevisit(last)
+	}
+	if state := self.revisitState(task.Id()); state != nil && state.Interval > 0 {
+		return last + state.Interval
+	}
+	return last + p.AdaptRevisitInterval(0, false)
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"github.com/crawlerclub/x/types"
+	"github.com/liuzl/store"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"strings"
+	"time"
+)
+
+// SeedInfo is a seed with the time it is crawled again
+type SeedInfo struct {
+	types.Task
+	// unix time of the next revisit in crontab, 0 if it is not scheduled,
+	// e.g. it is in the queue or not revisited
+	NextRun int64 `json:"next_run"`
+}
+
+// Seeds returns the seeds of crawler name with their next revisits
+func (self *Controller) Seeds(name string) ([]*SeedInfo, error) {
+	if _, err := self.GetCrawlerItem(name); err != nil {
+		return nil, err
+	}
+	var seeds []*SeedInfo
+	index := make(map[string]*SeedInfo)
+	prefix := util.BytesPrefix([]byte(name + "\t"))
+	err := self.Stores["seed"].ForEach(prefix, func(key, value []byte) (bool, error) {
+		seed := &SeedInfo{}
+		if e := store.BytesToObject(value, &seed.Task); e != nil {
+			return false, e
+		}
+		if state := self.revisitState(string(key)); state != nil {
+			// adapted to the changes of the page
+			seed.RevisitInterval = state.Interval
+		}
+		seeds = append(seeds, seed)
+		index[string(key)] = seed
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = self.Stores["next_run"].ForEach(prefix, func(key, value []byte) (bool, error) {
+		if seed, ok := index[string(key)]; ok {
+			if t, e := time.ParseInLocation(timeLayout, string(value), time.Local); e == nil {
+				seed.NextRun = t.Unix()
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seeds, nil
+}
+
+// scheduleSeed puts seed task to crontab to be revisited at next, which is
+// also kept in the next_run store by seed id for Seeds
+func (self *Controller) scheduleSeed(task types.Task, next int64) {
+	// keys of crontab are timeStr(next)\tid
+	key := timeStr(next) + "\t" + task.Id()
+	value, _ := store.ObjectToBytes(task)
+	self.Stores["crontab"].Put(key, value)
+	self.Stores["next_run"].Put(task.Id(), []byte(timeStr(next)))
+}
+
+// unscheduleSeed removes the next run of the seed of crontab key if it is
+// the one of key
+func (self *Controller) unscheduleSeed(key string) {
+	parts := strings.SplitN(key, "\t", 2)
+	if len(parts) != 2 {
+		return
+	}
+	if value, err := self.Stores["next_run"].Get(parts[1]); err == nil && string(value) == parts[0] {
+		self.Stores["next_run"].Delete(parts[1])
+	}
+}
+
+// indexNextRuns fills the next_run store from crontab if it is empty, e.g.
+// crontab is written by a version without it
+func (self *Controller) indexNextRuns() error {
+	empty := true
+	self.Stores["next_run"].ForEach(nil, func(key, value []byte) (bool, error) {
+		empty = false
+		return false, nil
+	})
+	if !empty {
+		return nil
+	}
+	return self.Stores["crontab"].ForEach(nil, func(key, value []byte) (bool, error) {
+		parts := strings.SplitN(string(key), "\t", 2)
+		if len(parts) != 2 {
+			return true, nil
+		}
+		// the earliest run of a seed comes first
+		if has, _ := self.Stores["next_run"].Has(parts[1]); !has {
+			self.Stores["next_run"].Put(parts[1], []byte(parts[0]))
+		}
+		return true, nil
+	})
+}
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"github.com/crawlerclub/x/types"
+	"github.com/golang/glog"
+	"github.com/liuzl/ds"
+	"sync"
+	"time"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// runningTask is a task being processed by a worker
+type runningTask struct {
+	types.Task
+	key   string // key in the running store
+	queue *ds.Queue
+}
+
+// sleep pauses for d, it returns early if exitCh is closed
+func sleep(exitCh chan int, d time.Duration) {
+	select {
+	case <-exitCh:
+	case <-time.After(d):
+	}
+}
+
+func (self *Controller) setRunning(worker int, task *runningTask) {
+	self.runningLock.Lock()
+	defer self.runningLock.Unlock()
+	if task == nil {
+		delete(self.running, worker)
+	} else {
+		self.running[worker] = task
+	}
+}
+
+// Stop tells the workers to exit after their current tasks, Run returns
+// when they exit or ShutdownTimeout is reached
+func (self *Controller) Stop() {
+	self.stopOnce.Do(func() {
+		close(self.exitCh)
+	})
+}
+
+// wait waits for the workers to exit, the tasks still running at the
+// deadline of shutdown are returned to their queues
+func (self *Controller) wait(wg *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-self.exitCh:
+	}
+	timeout := self.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	select {
+	case <-done:
+		glog.Info("all workers exited")
+	case <-time.After(timeout):
+		self.closeLock.Lock()
+		defer self.closeLock.Unlock()
+		self.stopped = true
+		self.runningLock.Lock()
+		defer self.runningLock.Unlock()
+		glog.Warning("shutdown timeout, return ", len(self.running), " running tasks to queues")
+		for worker, task := range self.running {
+			if _, err := task.queue.EnqueueObject(task.Task); err != nil {
+				// still in the running store, retried on next start
+				glog.Error(err)
+				continue
+			}
+			self.Stores["running"].Delete(task.key)
+			delete(self.running, worker)
+		}
+	}
+}
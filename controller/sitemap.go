@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"github.com/crawlerclub/x/crawler"
+	"github.com/crawlerclub/x/types"
+	"github.com/golang/glog"
+	"time"
+)
+
+// isRunning checks whether c is still the running instance of its crawler
+func (self *Controller) isRunning(c *crawler.Crawler) bool {
+	return self.Crawlers.Has(c)
+}
+
+// discoverSitemaps enqueues the urls found in sitemaps of crawler c, urls
+// whose lastmod is not changed since last discovery are skipped, and urls
+// without lastmod are enqueued on each discovery. It repeats every
+// Sitemap.RevisitInterval seconds until the crawler or controller is closed.
+func (self *Controller) discoverSitemaps(c *crawler.Crawler) {
+	conf := c.Conf
+	for {
+		select {
+		case <-self.exitCh:
+			return
+		default:
+		}
+		if !self.isRunning(c) {
+			return
+		}
+		count := 0
+		// c is held only while enqueueing, not while fetching sitemaps
+		err := c.DiscoverSitemaps(func(u crawler.SitemapUrl, parserName string) error {
+			release, ok := self.Crawlers.Hold(c)
+			if !ok {
+				return ErrCrawlerNotRunning
+			}
+			defer release()
+			self.closeLock.RLock()
+			defer self.closeLock.RUnlock()
+			if self.stopped {
+				return ErrStopped
+			}
+			if !self.acceptsTasks(conf.CrawlerName) {
+				return nil
+			}
+			key := conf.CrawlerName + "\t" + u.Loc
+			if u.LastMod != "" {
+				if lastMod, err := self.Stores["sitemap"].Get(key); err == nil && string(lastMod) == u.LastMod {
+					return nil // not changed
+				}
+			}
+			task := types.Task{
+				CrawlerName: conf.CrawlerName,
+				ParserName:  parserName,
+				Url:         u.Loc,
+			}
+			if err := self.enqueue(c, task); err != nil {
+				return err
+			}
+			count++
+			c.Stats.AddTasks(1)
+			if seen := seenKey(conf, u.Loc); seen != "" {
+				self.markSeen(conf.CrawlerName, seen, time.Now().Unix())
+			}
+			return self.Stores["sitemap"].Put(key, []byte(u.LastMod))
+		})
+		if err != nil {
+			glog.Error(err)
+		}
+		glog.Info(conf.CrawlerName, " sitemap discovery enqueued ", count, " tasks")
+		if conf.Sitemap.RevisitInterval <= 0 {
+			return
+		}
+		sleep(self.exitCh, time.Duration(conf.Sitemap.RevisitInterval)*time.Second)
+	}
+}
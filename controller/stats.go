@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/crawlerclub/x/crawler"
+	"github.com/golang/glog"
+	"sync"
+	"time"
+)
+
+const statsInterval = 10 * time.Second
+
+// loadStats returns the persisted stats of crawler name
+func (self *Controller) loadStats(name string) *crawler.Stats {
+	stats := crawler.NewStats()
+	value, err := self.Stores["stats"].Get(name)
+	if err != nil || value == nil {
+		return stats
+	}
+	if err = json.Unmarshal(value, stats); err != nil {
+		glog.Error(err)
+	}
+	return stats
+}
+
+func (self *Controller) saveStats(c *crawler.Crawler) {
+	value, err := c.Stats.Marshal()
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	if err = self.Stores["stats"].Put(c.Conf.CrawlerName, value); err != nil {
+		glog.Error(err)
+	}
+}
+
+// saveAllStats persists the stats of running crawlers, must be called with
+// closeLock held
+func (self *Controller) saveAllStats() {
+	for _, name := range self.Crawlers.Names() {
+		if c, release, ok := self.Crawlers.Acquire(name); ok {
+			self.saveStats(c)
+			release()
+		}
+	}
+}
+
+func (self *Controller) statsLoop(wg *sync.WaitGroup, exitCh chan int) {
+	defer wg.Done()
+	// store sizes are reported before the first interval
+	var d time.Duration
+	for {
+		select {
+		case <-exitCh:
+			return
+		case <-time.After(d):
+			self.closeLock.RLock()
+			if !self.stopped {
+				self.saveAllStats()
+				self.flushSinks()
+				self.countStores()
+			}
+			self.closeLock.RUnlock()
+			d = statsInterval
+		}
+	}
+}
+
+// flushSinks writes out the items buffered in the sinks of running crawlers,
+// must be called with closeLock held
+func (self *Controller) flushSinks() {
+	for _, name := range self.Crawlers.Names() {
+		if c, release, ok := self.Crawlers.Acquire(name); ok {
+			if err := c.Flush(); err != nil {
+				glog.Error(name, " flush sinks: ", err)
+			}
+			release()
+		}
+	}
+}
+
+// GetStats returns the stats of crawler name, from the running crawler or
+// the stats store
+func (self *Controller) GetStats(name string) (*crawler.StatsSnapshot, error) {
+	var s *crawler.StatsSnapshot
+	if c, release, ok := self.Crawlers.Acquire(name); ok {
+		s = c.Stats.Snapshot()
+		s.QueueDepth = c.TaskQueue.Length()
+		s.Running = true
+		release()
+	} else {
+		if _, err := self.GetCrawlerItem(name); err != nil {
+			return nil, err
+		}
+		s = self.loadStats(name).Snapshot()
+	}
+	s.CrawlerName = name
+	return s, nil
+}
+
+// AllStats returns the stats of all crawlers
+func (self *Controller) AllStats() ([]*crawler.StatsSnapshot, error) {
+	var names []string
+	err := self.Stores["crawler"].ForEach(nil, func(key, value []byte) (bool, error) {
+		names = append(names, string(key))
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ret := []*crawler.StatsSnapshot{}
+	for _, name := range names {
+		s, err := self.GetStats(name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
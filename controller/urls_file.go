@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"github.com/crawlerclub/x/crawler"
+	"github.com/crawlerclub/x/types"
+	"github.com/golang/glog"
+	"github.com/liuzl/store"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	ErrEmptyUrl        = errors.New("controller/urls_file.go empty url of urls_file line")
+	ErrCrawlerDraining = errors.New("controller/urls_file.go crawler is draining")
+)
+
+// checkpoint the urls_file progress every checkpointLines lines
+const checkpointLines = 1000
+
+// UrlsFileProgress records how far a url_set crawler has read its urls_file,
+// it is stored in the "urls_file" store with CrawlerName as key
+type UrlsFileProgress struct {
+	File    string `json:"file"`
+	Line    int64  `json:"line"`
+	ModTime int64  `json:"mod_time"`
+	Done    bool   `json:"done"`
+}
+
+func openUrlsFile(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	// gzip magic number: 0x1f 0x8b
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{gr, f}, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, f}, nil
+}
+
+// parseUrlsLine converts one line of urls_file to a Task, the line is either
+// a plain url or a json encoded Task. It returns nil for blank and comment lines
+func parseUrlsLine(line string, conf *types.CrawlerConf) (*types.Task, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	task := &types.Task{}
+	if strings.HasPrefix(line, "{") {
+		if err := json.Unmarshal([]byte(line), task); err != nil {
+			return nil, err
+		}
+		if task.Url == "" {
+			return nil, ErrEmptyUrl
+		}
+	} else {
+		task.Url = line
+	}
+	task.CrawlerName = conf.CrawlerName
+	if task.ParserName == "" {
+		task.ParserName = conf.StartParserName
+	}
+	return task, nil
+}
+
+func (self *Controller) getUrlsFileProgress(name string) *UrlsFileProgress {
+	progress := &UrlsFileProgress{}
+	value, err := self.Stores["urls_file"].Get(name)
+	if err != nil {
+		return progress
+	}
+	if err = store.BytesToObject(value, progress); err != nil {
+		glog.Error(err)
+		return &UrlsFileProgress{}
+	}
+	return progress
+}
+
+func (self *Controller) putUrlsFileProgress(name string, progress *UrlsFileProgress) {
+	value, err := store.ObjectToBytes(progress)
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	if err = self.Stores["urls_file"].Put(name, value); err != nil {
+		glog.Error(err)
+	}
+}
+
+// feedUrlsLine enqueues the task of line n of the urls_file of c and records
+// it in progress. It holds closeLock, so Finish does not close the stores
+// while the line is fed.
+func (self *Controller) feedUrlsLine(c *crawler.Crawler, text string, n int64,
+	progress *UrlsFileProgress) (bool, error) {
+	self.closeLock.RLock()
+	defer self.closeLock.RUnlock()
+	name := c.Conf.CrawlerName
+	if self.stopped {
+		return false, ErrStopped
+	}
+	if !self.isRunning(c) {
+		// closed or updated, keep the progress before this line
+		return false, ErrCrawlerNotRunning
+	}
+	if !self.acceptsTasks(name) {
+		// started again by Resume from this line
+		glog.Info(name, " draining, stop loading urls_file at line ", n)
+		return false, ErrCrawlerDraining
+	}
+	enqueued := false
+	task, err := parseUrlsLine(text, c.Conf)
+	if err != nil {
+		glog.Error(name, " urls_file line ", n, ": ", err)
+	} else if task != nil && self.shouldEnqueue(c, task) {
+		if err = self.enqueue(c, *task); err != nil {
+			// the queue is closed, keep the progress before this line
+			glog.Error(err)
+			return false, err
+		}
+		enqueued = true
+		c.Stats.AddTasks(1)
+	}
+	progress.Line = n
+	if n%checkpointLines == 0 {
+		self.putUrlsFileProgress(name, progress)
+	}
+	return enqueued, nil
+}
+
+// startFeeder runs feedUrlsFile for crawler c in background, it runs again
+// after the running one returns if there is one
+func (self *Controller) startFeeder(c *crawler.Crawler) {
+	self.feedLock.Lock()
+	defer self.feedLock.Unlock()
+	if _, ok := self.feeders[c]; ok {
+		self.feeders[c] = true
+		return
+	}
+	self.feeders[c] = false
+	go func() {
+		for {
+			self.feedUrlsFile(c)
+			self.feedLock.Lock()
+			if again := self.feeders[c]; !again || !self.isRunning(c) {
+				delete(self.feeders, c)
+				self.feedLock.Unlock()
+				return
+			}
+			self.feeders[c] = false
+			self.feedLock.Unlock()
+		}
+	}()
+}
+
+// feedUrlsFile streams the urls_file of a url_set crawler into its TaskQueue.
+// Lines already enqueued by a previous run are skipped, so a restart resumes
+// where it stopped; lines appended to a finished file are picked up when the
+// file is modified.
+func (self *Controller) feedUrlsFile(c *crawler.Crawler) error {
+	conf := c.Conf
+	name := conf.CrawlerName
+	fi, err := os.Stat(conf.UrlsFile)
+	if err != nil {
+		glog.Error(err)
+		return err
+	}
+	release, ok := self.Crawlers.Hold(c)
+	if !ok {
+		return ErrCrawlerNotRunning
+	}
+	defer release()
+	self.closeLock.RLock()
+	if self.stopped {
+		self.closeLock.RUnlock()
+		return ErrStopped
+	}
+	progress := self.getUrlsFileProgress(name)
+	self.closeLock.RUnlock()
+	if progress.File != conf.UrlsFile {
+		progress = &UrlsFileProgress{File: conf.UrlsFile}
+	}
+	if progress.Done && progress.ModTime == fi.ModTime().Unix() {
+		glog.Info(name, " urls_file ", conf.UrlsFile, " already loaded")
+		return nil
+	}
+	progress.ModTime = fi.ModTime().Unix()
+	progress.Done = false
+
+	f, err := openUrlsFile(conf.UrlsFile)
+	if err != nil {
+		glog.Error(err)
+		return err
+	}
+	defer f.Close()
+
+	glog.Info(name, " load urls_file ", conf.UrlsFile, " from line ", progress.Line)
+	defer func() {
+		// the stores are closed after Finish, the progress is saved at the
+		// last checkpoint then
+		self.closeLock.RLock()
+		if !self.stopped {
+			self.putUrlsFileProgress(name, progress)
+		}
+		self.closeLock.RUnlock()
+	}()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var line, count int64
+	for scanner.Scan() {
+		line++
+		if line <= progress.Line {
+			continue
+		}
+		enqueued, err := self.feedUrlsLine(c, scanner.Text(), line, progress)
+		if err != nil {
+			return err
+		}
+		if enqueued {
+			count++
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		glog.Error(err)
+		return err
+	}
+	progress.Done = true
+	glog.Info(name, " urls_file ", conf.UrlsFile, " loaded, ", count, " tasks enqueued")
+	return nil
+}
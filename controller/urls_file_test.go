@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/crawlerclub/x/crawler"
+	"github.com/crawlerclub/x/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestController(t *testing.T) (*Controller, func()) {
+	dir, err := ioutil.TempDir("", "controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctl := &Controller{}
+	if err = ctl.Init(dir, 1); err != nil {
+		t.Fatal(err)
+	}
+	return ctl, func() {
+		ctl.Finish()
+		os.RemoveAll(dir)
+	}
+}
+
+// newUrlSetCrawler puts a url_set crawler of file to ctl without feeding it
+func newUrlSetCrawler(t *testing.T, ctl *Controller, file string) *crawler.Crawler {
+	conf := &types.CrawlerConf{
+		CrawlerType:     "url_set",
+		CrawlerName:     "urls",
+		UrlsFile:        file,
+		StartParserName: "page",
+		ParseConfs:      map[string]types.ParseConf{"page": {ParserName: "page"}},
+	}
+	c := &crawler.Crawler{Conf: conf, Stats: crawler.NewStats()}
+	if err := c.InitTaskQueue(ctl.workDir + "/queue"); err != nil {
+		t.Fatal(err)
+	}
+	ctl.Crawlers.Put(c)
+	return c
+}
+
+func writeUrlsFile(t *testing.T, dir string, n int) string {
+	file := filepath.Join(dir, "urls.txt")
+	var content bytes.Buffer
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&content, "http://example.com/%d\n", i)
+	}
+	if err := ioutil.WriteFile(file, content.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestFeedUrlsFileDrain(t *testing.T) {
+	ctl, cleanup := newTestController(t)
+	defer cleanup()
+	c := newUrlSetCrawler(t, ctl, writeUrlsFile(t, ctl.workDir, 3))
+
+	ctl.Crawlers.SetState("urls", StateDraining)
+	if err := ctl.feedUrlsFile(c); err != ErrCrawlerDraining {
+		t.Errorf("got %v, want ErrCrawlerDraining", err)
+	}
+	if p := ctl.getUrlsFileProgress("urls"); p.Line != 0 || p.Done {
+		t.Errorf("lines consumed while draining: %+v", p)
+	}
+	ctl.Crawlers.SetState("urls", StateRunning)
+	if err := ctl.feedUrlsFile(c); err != nil {
+		t.Fatal(err)
+	}
+	if p := ctl.getUrlsFileProgress("urls"); p.Line != 3 || !p.Done {
+		t.Errorf("unexpected progress %+v", p)
+	}
+	if n := c.TaskQueue.Length(); n != 3 {
+		t.Errorf("got %d tasks, want 3", n)
+	}
+}
+
+func TestParseUrlsLine(t *testing.T) {
+	conf := &types.CrawlerConf{CrawlerName: "urls", StartParserName: "page"}
+	var testcases = []struct {
+		line   string
+		url    string
+		parser string
+		err    bool
+	}{
+		{"  http://example.com/a \r", "http://example.com/a", "page", false},
+		{`{"url": "http://example.com/b", "parser_name": "list"}`, "http://example.com/b", "list", false},
+		{`{"url": "http://example.com/c"}`, "http://example.com/c", "page", false},
+		{`{"parser_name": "list"}`, "", "", true},
+		{`{"url": `, "", "", true},
+		{"# comment", "", "", false},
+		{"   ", "", "", false},
+	}
+	for _, c := range testcases {
+		task, err := parseUrlsLine(c.line, conf)
+		if (err != nil) != c.err {
+			t.Errorf("%q: got error %v", c.line, err)
+			continue
+		}
+		if c.url == "" {
+			if task != nil {
+				t.Errorf("%q: got task %+v, want nil", c.line, task)
+			}
+			continue
+		}
+		if task == nil || task.Url != c.url || task.ParserName != c.parser || task.CrawlerName != "urls" {
+			t.Errorf("%q: got task %+v", c.line, task)
+		}
+	}
+	if _, err := parseUrlsLine(`{"parser_name": "list"}`, conf); err != ErrEmptyUrl {
+		t.Errorf("got %v, want ErrEmptyUrl", err)
+	}
+}
+
+// dequeueUrls takes all tasks of c and returns their urls
+func dequeueUrls(t *testing.T, c *crawler.Crawler) []string {
+	var urls []string
+	for c.TaskQueue.Length() > 0 {
+		item, err := c.TaskQueue.Dequeue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var task types.Task
+		if err = item.ToObject(&task); err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, task.Url)
+	}
+	return urls
+}
+
+func TestFeedUrlsFileResume(t *testing.T) {
+	ctl, cleanup := newTestController(t)
+	defer cleanup()
+	file := writeUrlsFile(t, ctl.workDir, 5)
+	c := newUrlSetCrawler(t, ctl, file)
+
+	// stopped after line 3 by a previous run
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctl.putUrlsFileProgress("urls", &UrlsFileProgress{File: file, Line: 3, ModTime: fi.ModTime().Unix()})
+	if err = ctl.feedUrlsFile(c); err != nil {
+		t.Fatal(err)
+	}
+	urls := dequeueUrls(t, c)
+	if len(urls) != 2 || urls[0] != "http://example.com/4" || urls[1] != "http://example.com/5" {
+		t.Errorf("got urls %v, want 4 and 5", urls)
+	}
+	if p := ctl.getUrlsFileProgress("urls"); p.Line != 5 || !p.Done {
+		t.Errorf("unexpected progress %+v", p)
+	}
+
+	// not loaded again until it is modified
+	if err = ctl.feedUrlsFile(c); err != nil {
+		t.Fatal(err)
+	}
+	if urls = dequeueUrls(t, c); len(urls) != 0 {
+		t.Errorf("got urls %v of a loaded file", urls)
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, "http://example.com/6")
+	f.Close()
+	later := fi.ModTime().Add(time.Minute)
+	if err = os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err = ctl.feedUrlsFile(c); err != nil {
+		t.Fatal(err)
+	}
+	if urls = dequeueUrls(t, c); len(urls) != 1 || urls[0] != "http://example.com/6" {
+		t.Errorf("got urls %v, want 6", urls)
+	}
+}
+
+func TestFeedUrlsFileGzip(t *testing.T) {
+	ctl, cleanup := newTestController(t)
+	defer cleanup()
+	file := filepath.Join(ctl.workDir, "urls.txt.gz")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := gzip.NewWriter(f)
+	fmt.Fprint(w, "# urls\nhttp://example.com/1\n\n{\"url\": \"http://example.com/2\"}\n")
+	w.Close()
+	f.Close()
+	c := newUrlSetCrawler(t, ctl, file)
+	if err = ctl.feedUrlsFile(c); err != nil {
+		t.Fatal(err)
+	}
+	if urls := dequeueUrls(t, c); len(urls) != 2 || urls[1] != "http://example.com/2" {
+		t.Errorf("got urls %v", urls)
+	}
+	if p := ctl.getUrlsFileProgress("urls"); p.Line != 4 || !p.Done {
+		t.Errorf("unexpected progress %+v", p)
+	}
+}
+
+func TestFeedUrlsFileFinish(t *testing.T) {
+	ctl, cleanup := newTestController(t)
+	defer cleanup()
+	c := newUrlSetCrawler(t, ctl, writeUrlsFile(t, ctl.workDir, 100000))
+	ctl.startFeeder(c)
+	for c.TaskQueue.Length() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctl.Finish()
+	// the feeder exits without using the closed stores
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(time.Millisecond) {
+		ctl.feedLock.Lock()
+		n := len(ctl.feeders)
+		ctl.feedLock.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("feeder not stopped")
+		}
+	}
+}
@@ -4,14 +4,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/crawlerclub/x/metrics"
 	"github.com/crawlerclub/x/parser"
+	"github.com/crawlerclub/x/sink"
 	"github.com/crawlerclub/x/types"
-	"github.com/liuzl/dl"
+	"github.com/golang/glog"
 	"github.com/liuzl/ds"
 	"github.com/tkuchiki/parsetime"
-	"golang.org/x/net/context"
-	"gopkg.in/olivere/elastic.v5"
 	"io/ioutil"
+	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -25,7 +27,13 @@ var (
 type Crawler struct {
 	Conf      *types.CrawlerConf
 	TaskQueue *ds.Queue
-	es        *elastic.Client
+	// counters of Process, nothing is counted if nil
+	Stats *Stats
+	// cookies of the login, no login if nil
+	Session *Session
+	// closed on exit, Process stops waiting for busy hosts then
+	Exit  <-chan int
+	sinks sink.MultiSink
 }
 
 func (self *Crawler) LoadConfFromBytes(str []byte) error {
@@ -38,21 +46,22 @@ func (self *Crawler) LoadConfFromBytes(str []byte) error {
 	if !ok {
 		return err
 	}
-	return self.InitEs()
+	self.Conf.Compile()
+	return self.InitSinks()
 }
 
-func (self *Crawler) InitEs() error {
+// InitSinks creates the item sinks configured by sinks and es_uri
+func (self *Crawler) InitSinks() error {
 	if self.Conf == nil {
-		return errors.New("CrawlerConf is nil")
+		return ErrEmptyCrawlerConf
 	}
 	var err error
-	if len(self.Conf.EsUri) > 0 {
-		self.es, err = elastic.NewClient(
-			elastic.SetURL(self.Conf.EsUri),
-			elastic.SetMaxRetries(10))
-		if err != nil {
-			return err
-		}
+	self.sinks, err = sink.NewMultiSink(self.Conf)
+	if err != nil {
+		return err
+	}
+	if len(self.sinks) == 0 {
+		glog.Warning(self.Conf.CrawlerName, " has no sinks, items are dropped")
 	}
 	return nil
 }
@@ -86,6 +95,40 @@ func (self *Crawler) Close() {
 	if self.TaskQueue != nil {
 		self.TaskQueue.Close()
 	}
+	if self.sinks != nil {
+		if err := self.sinks.Close(); err != nil {
+			glog.Error(err)
+		}
+	}
+}
+
+// waitPoliteness checks robots.txt and blocks until the host of rawurl can
+// be requested, or returns ErrHostBusy if it can not be within maxHostWait.
+// The returned func must be called after downloading.
+func (self *Crawler) waitPoliteness(rawurl string) (func(), error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	conf := &self.Conf.Politeness
+	delay := time.Duration(conf.HostDelay) * time.Millisecond
+	if conf.ObeyRobots {
+		agent := conf.RobotsAgent
+		if agent == "" {
+			agent = defaultRobotsAgent
+		}
+		robots := DefaultRobots.Get(u)
+		if robots.Unavailable() {
+			return nil, ErrRobotsUnavailable
+		}
+		if !robots.Allowed(agent, u.RequestURI()) {
+			return nil, ErrDisallowed
+		}
+		if d := robots.CrawlDelay(agent); d > delay {
+			delay = d
+		}
+	}
+	return DefaultHostLimiter.Acquire(u.Host, delay, conf.HostConcurrency, maxHostWait, self.Exit)
 }
 
 func (self *Crawler) Process(
@@ -102,17 +145,38 @@ func (self *Crawler) Process(
 			return nil, nil, errors.New(
 				fmt.Sprintf("no parser_type %s found!", urlParser.ParserType))
 		}
-		req := &dl.HttpRequest{Url: task.Url, Platform: "pc", Timeout: 60}
-		resp := dl.Download(req)
-		if resp.Error != nil {
-			return nil, nil, resp.Error
+		release, err := self.waitPoliteness(task.Url)
+		if err != nil {
+			return nil, nil, err
+		}
+		name := self.Conf.CrawlerName
+		start := time.Now()
+		resp, err := self.fetch(self.Conf.Fetch.Merge(&urlParser.Fetch), task)
+		release()
+		latency := time.Since(start)
+		metrics.DownloadDuration.WithLabelValues(name).Observe(latency.Seconds())
+		if resp == nil {
+			self.Stats.AddFetch(0, 0, latency)
+			metrics.Downloads.WithLabelValues(name, "error").Inc()
+			return nil, nil, err
+		}
+		self.Stats.AddFetch(resp.StatusCode, resp.Size, latency)
+		metrics.Downloads.WithLabelValues(name, strconv.Itoa(resp.StatusCode)).Inc()
+		if err != nil {
+			return nil, nil, err
 		}
 		//fmt.Println(resp.Text)
-		tasks, items, err := uParser.Parse(resp.Text, resp.Url, &urlParser)
+		start = time.Now()
+		tasks, items, err := uParser.Parse(resp.Text, resp.Url, &urlParser, task.GetData())
+		metrics.ParseDuration.WithLabelValues(name, task.ParserName).Observe(time.Since(start).Seconds())
 		if err != nil {
+			self.Stats.AddParseError()
+			metrics.ParseErrors.WithLabelValues(name, task.ParserName).Inc()
 			return nil, nil, err
 		}
 
+		task.Fingerprint = Fingerprint(tasks, items)
+
 		lastModified := time.Now().Unix()
 		for _, item := range items {
 			if value, ok := item["last_modified_"]; ok {
@@ -143,6 +207,14 @@ func (self *Crawler) Process(
 	}
 }
 
+// Flush writes out the items buffered in sinks
+func (self *Crawler) Flush() error {
+	if len(self.sinks) == 0 {
+		return nil
+	}
+	return self.sinks.Flush()
+}
+
 func (self *Crawler) Save(item map[string]interface{}) error {
 	if self.Conf == nil {
 		return ErrEmptyCrawlerConf
@@ -150,23 +222,10 @@ func (self *Crawler) Save(item map[string]interface{}) error {
 	if item == nil {
 		return ErrNilItem
 	}
-	if self.es == nil {
+	if len(self.sinks) == 0 {
 		return nil
 	}
-	if esType, ok := item["es_type"]; ok {
-		ctx := context.Background()
-		t, _ := json.Marshal(item)
-		var err error
-		if id, has := item["id"]; has {
-			_, err = self.es.Index().Index(self.Conf.CrawlerName).
-				Type(esType.(string)).Id(id.(string)).BodyString(string(t)).Do(ctx)
-		} else {
-			_, err = self.es.Index().Index(self.Conf.CrawlerName).
-				Type(esType.(string)).BodyString(string(t)).Do(ctx)
-		}
-		return err
-	}
-	return nil
+	return self.sinks.Save(item)
 }
 
 func (self *Crawler) Test() (map[string]interface{}, error) {
@@ -0,0 +1,238 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/crawlerclub/x/types"
+	"golang.org/x/net/html/charset"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBodyTooLarge      = errors.New("crawler/fetch.go response body too large")
+	ErrContentTypeDenied = errors.New("crawler/fetch.go content type not allowed")
+)
+
+const (
+	defaultFetchTimeout = 60
+	defaultMaxBodySize  = 10 * 1024 * 1024
+	defaultBodyType     = "application/x-www-form-urlencoded"
+	defaultUserAgent    = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/63.0.3239.132 Safari/537.36"
+)
+
+var (
+	directTransport = &http.Transport{
+		MaxIdleConnsPerHost: 8,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	// the proxy is taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	proxyTransport = &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConnsPerHost: 8,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	// the proxy of DefaultProxyPool is taken from the context of requests
+	poolTransport = &http.Transport{
+		Proxy:               contextProxy,
+		MaxIdleConnsPerHost: 8,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+)
+
+type proxyKey struct{}
+
+func contextProxy(req *http.Request) (*url.URL, error) {
+	u, _ := req.Context().Value(proxyKey{}).(*url.URL)
+	return u, nil
+}
+
+// StatusError is returned by Fetch for responses whose status is not 2xx
+type StatusError struct {
+	Url        string
+	StatusCode int
+}
+
+func (self *StatusError) Error() string {
+	return fmt.Sprintf("crawler/fetch.go %s: status %d", self.Url, self.StatusCode)
+}
+
+// Permanent reports whether err of Fetch is an outcome that retrying does not
+// change, e.g. status 404, a denied content type or a too large body. Only
+// 5xx and 429 of statuses are worth retrying.
+func Permanent(err error) bool {
+	switch e := err.(type) {
+	case *StatusError:
+		return e.StatusCode < 500 && e.StatusCode != http.StatusTooManyRequests
+	}
+	return err == ErrBodyTooLarge || err == ErrContentTypeDenied
+}
+
+type FetchResponse struct {
+	// the url after redirects
+	Url         string
+	StatusCode  int
+	ContentType string
+	// bytes of the body read
+	Size int
+	// body decoded to utf-8
+	Text string
+}
+
+// Fetch requests the url of task with conf, the body in the data of task is
+// sent by POST and PUT requests, and the referer in it is sent unless conf
+// has one. Cookies are kept in jar if it is not nil. The response is
+// returned with the errors of status, content type, body size and captcha,
+// it is nil on network errors. The proxy of DefaultProxyPool for the host of
+// the crawler of task is used if proxy is on, failures are reported to it.
+func Fetch(conf *types.FetchConf, task *types.Task, jar http.CookieJar) (*FetchResponse, error) {
+	if conf == nil {
+		conf = &types.FetchConf{}
+	}
+	method := strings.ToUpper(conf.Method)
+	if method == "" {
+		method = "GET"
+	}
+	data := task.GetData()
+	var body io.Reader
+	if method == "POST" || method == "PUT" {
+		body = strings.NewReader(data.Body)
+	}
+	req, err := http.NewRequest(method, task.Url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		bodyType := conf.BodyType
+		if bodyType == "" {
+			bodyType = defaultBodyType
+		}
+		req.Header.Set("Content-Type", bodyType)
+	}
+	ua := conf.UserAgent
+	if ua == "" {
+		ua = defaultUserAgent
+	}
+	req.Header.Set("User-Agent", ua)
+	if data.Referer != "" {
+		req.Header.Set("Referer", data.Referer)
+	}
+	for key, value := range conf.Headers {
+		req.Header.Set(key, value)
+	}
+	for name, value := range conf.Cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	client := &http.Client{Transport: directTransport, Jar: jar,
+		Timeout: time.Duration(timeout) * time.Second}
+	var proxy *url.URL
+	if conf.Proxy == "on" {
+		client.Transport = proxyTransport
+		if DefaultProxyPool.Len() > 0 {
+			if proxy, err = DefaultProxyPool.Get(task.CrawlerName, req.URL.Host); err != nil {
+				return nil, err
+			}
+			req = req.WithContext(context.WithValue(req.Context(), proxyKey{}, proxy))
+			client.Transport = poolTransport
+		}
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		DefaultProxyPool.Fail(proxy)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	ret := &FetchResponse{
+		Url:         resp.Request.URL.String(),
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if blockedStatus(resp.StatusCode) {
+			DefaultProxyPool.Fail(proxy)
+		}
+		return ret, &StatusError{Url: task.Url, StatusCode: resp.StatusCode}
+	}
+	if !allowedContentType(conf.ContentTypes, ret.ContentType) {
+		return ret, ErrContentTypeDenied
+	}
+	max := conf.MaxBodySize
+	if max <= 0 {
+		max = defaultMaxBodySize
+	}
+	if resp.ContentLength > max {
+		return ret, ErrBodyTooLarge
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	ret.Size = len(content)
+	if err != nil {
+		DefaultProxyPool.Fail(proxy)
+		return ret, err
+	}
+	if int64(len(content)) > max {
+		return ret, ErrBodyTooLarge
+	}
+	ret.Text = decode(content, ret.ContentType)
+	if re := conf.Captcha(); re != nil && re.MatchString(ret.Text) {
+		DefaultProxyPool.Captcha(proxy)
+		return ret, ErrCaptcha
+	}
+	DefaultProxyPool.Success(proxy, time.Since(start), ret.Size)
+	return ret, nil
+}
+
+// blockedStatus reports whether status may be caused by the proxy
+func blockedStatus(status int) bool {
+	return status == http.StatusForbidden || status == http.StatusProxyAuthRequired ||
+		status == http.StatusTooManyRequests || status >= 500
+}
+
+// allowedContentType matches the media type of contentType against allowed,
+// e.g. text/html or text/*
+func allowedContentType(allowed []string, contentType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range allowed {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == mediaType ||
+			(strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// decode converts data to utf-8 by the charset of contentType, or the meta
+// tags of html
+func decode(data []byte, contentType string) string {
+	r, err := charset.NewReader(bytes.NewReader(data), contentType)
+	if err != nil {
+		return string(data)
+	}
+	text, err := ioutil.ReadAll(r)
+	if err != nil {
+		return string(data)
+	}
+	return string(text)
+}
@@ -0,0 +1,126 @@
+package crawler
+
+import (
+	"github.com/crawlerclub/x/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/search":
+			body, _ := ioutil.ReadAll(r.Body)
+			cookie, _ := r.Cookie("sid")
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(strings.Join([]string{r.Method, string(body),
+				r.Header.Get("Content-Type"), r.Header.Get("User-Agent"),
+				r.Header.Get("Referer"), cookie.Value}, "|")))
+		case "/gbk":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><head><meta charset="gbk"></head><body>` +
+				"\xc4\xe3\xba\xc3</body></html>"))
+		case "/big":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(strings.Repeat("a", 2048)))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	conf := &types.FetchConf{
+		Method:    "POST",
+		UserAgent: "crawler",
+		Headers:   map[string]string{"Referer": "http://a.com/"},
+		Cookies:   map[string]string{"sid": "123"},
+	}
+	resp, err := Fetch(conf, &types.Task{Url: server.URL + "/search", Data: "q=go"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "POST|q=go|application/x-www-form-urlencoded|crawler|http://a.com/|123"
+	if resp.StatusCode != 200 || resp.Text != want {
+		t.Errorf("got %d %s, want 200 %s", resp.StatusCode, resp.Text, want)
+	}
+
+	// referer of conf wins
+	task := &types.Task{Url: server.URL + "/search"}
+	task.SetData(&types.TaskData{Body: "q=x", Referer: "http://b.com/"})
+	resp, err = Fetch(conf, task, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "POST|q=x|application/x-www-form-urlencoded|crawler|http://a.com/|123"
+	if resp.Text != want {
+		t.Errorf("got %s, want %s", resp.Text, want)
+	}
+	conf.Headers = nil
+	resp, err = Fetch(conf, task, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "POST|q=x|application/x-www-form-urlencoded|crawler|http://b.com/|123"
+	if resp.Text != want {
+		t.Errorf("got %s, want %s", resp.Text, want)
+	}
+
+	resp, err = Fetch(nil, &types.Task{Url: server.URL + "/gbk"}, nil)
+	if err != nil || !strings.Contains(resp.Text, "你好") {
+		t.Error("gbk page not decoded:", resp.Text, err)
+	}
+
+	resp, err = Fetch(nil, &types.Task{Url: server.URL + "/missing"}, nil)
+	if e, ok := err.(*StatusError); !ok || e.StatusCode != 404 || resp.StatusCode != 404 {
+		t.Error("got", err, "want status 404")
+	}
+	for _, c := range []struct {
+		err       error
+		permanent bool
+	}{
+		{err, true},
+		{ErrBodyTooLarge, true},
+		{ErrContentTypeDenied, true},
+		{&StatusError{StatusCode: 410}, true},
+		{&StatusError{StatusCode: 429}, false},
+		{&StatusError{StatusCode: 503}, false},
+		{ErrRobotsUnavailable, false},
+	} {
+		if Permanent(c.err) != c.permanent {
+			t.Error(c.err, "permanent is not", c.permanent)
+		}
+	}
+
+	conf = &types.FetchConf{MaxBodySize: 1024}
+	if _, err = Fetch(conf, &types.Task{Url: server.URL + "/big"}, nil); err != ErrBodyTooLarge {
+		t.Error("got", err, "want ErrBodyTooLarge")
+	}
+
+	conf = &types.FetchConf{ContentTypes: []string{"text/*"}}
+	if _, err = Fetch(conf, &types.Task{Url: server.URL + "/image"}, nil); err != ErrContentTypeDenied {
+		t.Error("got", err, "want ErrContentTypeDenied")
+	}
+	if _, err = Fetch(conf, &types.Task{Url: server.URL + "/big"}, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFetchConfMerge(t *testing.T) {
+	base := &types.FetchConf{Timeout: 30, Proxy: "on",
+		Headers: map[string]string{"Referer": "a", "Accept": "b"}}
+	conf := base.Merge(&types.FetchConf{Method: "POST", Proxy: "off",
+		Headers: map[string]string{"Referer": "c"}})
+	if conf.Method != "POST" || conf.Timeout != 30 || conf.Proxy != "off" ||
+		conf.Headers["Referer"] != "c" || conf.Headers["Accept"] != "b" {
+		t.Errorf("unexpected merged conf %+v", conf)
+	}
+	if base.Headers["Referer"] != "a" || base.Method != "" {
+		t.Error("base conf changed by Merge")
+	}
+}
@@ -0,0 +1,37 @@
+package crawler
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/crawlerclub/x/types"
+)
+
+// fields filled at crawl time, they change on every visit
+var volatileFields = []string{"crawl_time_"}
+
+// Fingerprint returns the hash of the tasks and items parsed from a page, it
+// changes only if the content extracted from the page changes
+func Fingerprint(tasks []types.Task, items []map[string]interface{}) string {
+	h := sha1.New()
+	for _, task := range tasks {
+		h.Write([]byte(task.ParserName + "\t" + task.Url + "\n"))
+	}
+	for _, item := range items {
+		v := make(map[string]interface{}, len(item))
+		for key, value := range item {
+			v[key] = value
+		}
+		for _, key := range volatileFields {
+			delete(v, key)
+		}
+		// keys of maps are sorted by json
+		b, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		h.Write(b)
+		h.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,25 @@
+package crawler
+
+import (
+	"github.com/crawlerclub/x/types"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	tasks := []types.Task{{ParserName: "article", Url: "http://a.com/1"}}
+	items := []map[string]interface{}{{"title": "a", "crawl_time_": "2018-01-01 00:00:00"}}
+	fp := Fingerprint(tasks, items)
+	items[0]["crawl_time_"] = "2018-01-02 00:00:00"
+	if Fingerprint(tasks, items) != fp {
+		t.Error("fingerprint changed by crawl_time_")
+	}
+	items[0]["title"] = "b"
+	if Fingerprint(tasks, items) == fp {
+		t.Error("fingerprint not changed by title")
+	}
+	items[0]["title"] = "a"
+	tasks = append(tasks, types.Task{ParserName: "article", Url: "http://a.com/2"})
+	if Fingerprint(tasks, items) == fp {
+		t.Error("fingerprint not changed by new tasks")
+	}
+}
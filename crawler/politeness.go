@@ -0,0 +1,107 @@
+package crawler
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDisallowed = errors.New("crawler/politeness.go disallowed by robots.txt")
+	// tasks are retried later, robots.txt is fetched again by then
+	ErrRobotsUnavailable = errors.New("crawler/politeness.go robots.txt unavailable")
+	// tasks are postponed instead of holding workers for a busy host
+	ErrHostBusy = errors.New("crawler/politeness.go host busy")
+)
+
+const (
+	defaultRobotsAgent = "crawlerclub"
+	maxIdleHosts       = 10000
+	// far below the lease of running tasks
+	maxHostWait = 10 * time.Second
+)
+
+// DefaultRobots and DefaultHostLimiter are shared by all crawlers, so the
+// politeness rules of a host are enforced across all controller workers
+var (
+	DefaultRobots      = NewRobotsCache()
+	DefaultHostLimiter = NewHostLimiter()
+)
+
+type hostState struct {
+	next    time.Time // earliest time of the next request
+	running int
+}
+
+// HostLimiter limits the request interval and concurrency per host
+type HostLimiter struct {
+	sync.Mutex
+	hosts map[string]*hostState
+}
+
+func NewHostLimiter() *HostLimiter {
+	return &HostLimiter{hosts: make(map[string]*hostState)}
+}
+
+// Acquire blocks until a request to host is permitted by delay and
+// concurrency, concurrency <= 0 means no limit. It returns ErrHostBusy at once
+// if the request is not permitted within maxWait, or when exit is closed. The
+// returned func must be called when the request is done.
+func (self *HostLimiter) Acquire(host string, delay time.Duration, concurrency int,
+	maxWait time.Duration, exit <-chan int) (func(), error) {
+	deadline := time.Now().Add(maxWait)
+	for {
+		self.Lock()
+		now := time.Now()
+		st, ok := self.hosts[host]
+		if !ok {
+			if len(self.hosts) >= maxIdleHosts {
+				self.sweep(now)
+			}
+			st = &hostState{}
+			self.hosts[host] = st
+		}
+		if (concurrency <= 0 || st.running < concurrency) && !now.Before(st.next) {
+			st.running++
+			st.next = now.Add(delay)
+			self.Unlock()
+			return func() { self.release(host) }, nil
+		}
+		wait := st.next.Sub(now)
+		self.Unlock()
+		if wait <= 0 {
+			// waiting for a running request to finish
+			wait = 100 * time.Millisecond
+		}
+		if now.Add(wait).After(deadline) {
+			return nil, ErrHostBusy
+		}
+		select {
+		case <-exit:
+			return nil, ErrHostBusy
+		case <-time.After(wait):
+		}
+	}
+}
+
+// sweep removes the idle hosts, must be called with lock held
+func (self *HostLimiter) sweep(now time.Time) {
+	for host, st := range self.hosts {
+		if st.running <= 0 && now.After(st.next) {
+			delete(self.hosts, host)
+		}
+	}
+}
+
+func (self *HostLimiter) release(host string) {
+	self.Lock()
+	defer self.Unlock()
+	st, ok := self.hosts[host]
+	if !ok {
+		return
+	}
+	st.running--
+	if st.running <= 0 && time.Now().After(st.next) {
+		delete(self.hosts, host)
+	}
+}
@@ -0,0 +1,43 @@
+package crawler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostLimiterBusy(t *testing.T) {
+	limiter := NewHostLimiter()
+	release, err := limiter.Acquire("a.com", time.Hour, 0, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	// the next slot is beyond maxWait, fail at once
+	start := time.Now()
+	if _, err = limiter.Acquire("a.com", time.Hour, 0, time.Second, nil); err != ErrHostBusy {
+		t.Errorf("got %v, want ErrHostBusy", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("waited %v for a busy host", d)
+	}
+	// other hosts are not limited
+	release, err = limiter.Acquire("b.com", 0, 1, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// waiting for the running request gives up on exit
+	exit := make(chan int)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(exit)
+	}()
+	if _, err = limiter.Acquire("b.com", 0, 1, time.Minute, exit); err != ErrHostBusy {
+		t.Errorf("got %v, want ErrHostBusy", err)
+	}
+	release()
+	release, err = limiter.Acquire("b.com", 0, 1, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
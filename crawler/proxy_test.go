@@ -0,0 +1,99 @@
+package crawler
+
+import (
+	"fmt"
+	"github.com/crawlerclub/x/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProxyPool(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/captcha" {
+			fmt.Fprint(w, "enter the captcha")
+			return
+		}
+		fmt.Fprint(w, "via good ", r.URL.Host, r.URL.Path)
+	}))
+	defer good.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	file, err := ioutil.TempFile("", "proxies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	fmt.Fprintf(file, "# proxies\n%s\n\n%s\n", good.URL, strings.TrimPrefix(dead.URL, "http://"))
+	file.Close()
+
+	pool := NewProxyPool()
+	pool.Source, pool.MaxFailures = file.Name(), 2
+	if err = pool.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Len() != 2 {
+		t.Fatalf("got %d proxies, want 2", pool.Len())
+	}
+	defer func(p *ProxyPool) { DefaultProxyPool = p }(DefaultProxyPool)
+	DefaultProxyPool = pool
+
+	conf := &types.FetchConf{Proxy: "on", CaptchaPattern: "captcha"}
+	fetch := func(rawurl string) (*FetchResponse, error) {
+		return Fetch(conf, &types.Task{CrawlerName: "c", Url: rawurl}, nil)
+	}
+	// a.com is assigned to good, b.com to dead
+	for i := 0; i < 2; i++ {
+		resp, err := fetch("http://a.com/x")
+		if err != nil || resp.Text != "via good a.com/x" {
+			t.Fatal(resp, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = fetch("http://b.com/x"); err == nil {
+			t.Fatal("fetched through the dead proxy")
+		}
+	}
+	// dead is banned, b.com moves to good
+	resp, err := fetch("http://b.com/y")
+	if err != nil || resp.Text != "via good b.com/y" {
+		t.Fatal(resp, err)
+	}
+	stats := pool.Stats()
+	if stats[0].Requests != 3 || stats[0].Assigned != 2 || stats[0].Bytes == 0 ||
+		stats[1].Failures != 2 || stats[1].Bans != 1 || stats[1].BannedUntil.IsZero() {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if _, err = fetch("http://a.com/captcha"); err != ErrCaptcha {
+		t.Errorf("got %v, want ErrCaptcha", err)
+	}
+	if _, err = fetch("http://a.com/x"); err != ErrNoProxy {
+		t.Errorf("got %v, want ErrNoProxy", err)
+	}
+
+	pool.CheckUrl = "http://check.com/"
+	pool.Check()
+	stats = pool.Stats()
+	if !stats[0].Healthy || stats[1].Healthy || stats[0].LastCheck.IsZero() {
+		t.Errorf("unexpected stats after check %+v", stats)
+	}
+
+	// loaded again from an api, stats are kept
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, good.URL)
+	}))
+	defer api.Close()
+	pool.Source = api.URL
+	if err = pool.Load(); err != nil {
+		t.Fatal(err)
+	}
+	stats = pool.Stats()
+	if len(stats) != 1 || stats[0].Requests != 4 || stats[0].Captchas != 1 {
+		t.Errorf("unexpected stats after reload %+v", stats)
+	}
+}
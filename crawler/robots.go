@@ -0,0 +1,251 @@
+package crawler
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxRobotsSize  = 512 * 1024
+	maxRobotsHosts = 10000
+	robotsTTL      = 24 * time.Hour
+	// robots.txt failed with 5xx or network errors are fetched again sooner
+	robotsErrorTTL = time.Minute
+)
+
+// Robots is a parsed robots.txt
+type Robots struct {
+	Sitemaps []string
+	groups   []*robotsGroup
+	// robots.txt failed with 5xx or network errors, nothing is allowed
+	// until it is fetched again
+	unavailable bool
+}
+
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// ParseRobots parses the content of robots.txt
+func ParseRobots(content string) *Robots {
+	robots := &Robots{}
+	var group *robotsGroup
+	lastIsAgent := false
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+		switch key {
+		case "user-agent":
+			if !lastIsAgent {
+				group = &robotsGroup{}
+				robots.groups = append(robots.groups, group)
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+			lastIsAgent = true
+			continue
+		case "allow", "disallow":
+			if group != nil && (value != "" || key == "allow") {
+				group.rules = append(group.rules, newRobotsRule(key == "allow", value))
+			}
+		case "crawl-delay":
+			if group != nil {
+				if d, err := strconv.ParseFloat(value, 64); err == nil && d > 0 {
+					group.crawlDelay = time.Duration(d * float64(time.Second))
+				}
+			}
+		case "sitemap":
+			if value != "" {
+				robots.Sitemaps = append(robots.Sitemaps, value)
+			}
+		}
+		lastIsAgent = false
+	}
+	return robots
+}
+
+func newRobotsRule(allow bool, pattern string) robotsRule {
+	rule := robotsRule{allow: allow, pattern: pattern}
+	if strings.ContainsAny(pattern, "*$") {
+		expr := regexp.QuoteMeta(pattern)
+		expr = strings.Replace(expr, `\*`, ".*", -1)
+		if strings.HasSuffix(expr, `\$`) {
+			expr = strings.TrimSuffix(expr, `\$`) + "$"
+		}
+		rule.re, _ = regexp.Compile("^" + expr)
+	}
+	return rule
+}
+
+func (rule *robotsRule) match(path string) bool {
+	if rule.re != nil {
+		return rule.re.MatchString(path)
+	}
+	return strings.HasPrefix(path, rule.pattern)
+}
+
+// group returns the group of the most specific user-agent matching agent
+func (self *Robots) group(agent string) *robotsGroup {
+	agent = strings.ToLower(agent)
+	var ret, all *robotsGroup
+	longest := 0
+	for _, g := range self.groups {
+		for _, a := range g.agents {
+			if a == "*" {
+				if all == nil {
+					all = g
+				}
+			} else if strings.Contains(agent, a) && len(a) > longest {
+				ret, longest = g, len(a)
+			}
+		}
+	}
+	if ret == nil {
+		return all
+	}
+	return ret
+}
+
+// Unavailable reports whether robots.txt could not be fetched, the rules of
+// the site are unknown then
+func (self *Robots) Unavailable() bool {
+	return self.unavailable
+}
+
+// Allowed reports whether agent may fetch path, the longest matched rule wins
+// and allow wins on ties
+func (self *Robots) Allowed(agent, path string) bool {
+	if self.unavailable {
+		return false
+	}
+	g := self.group(agent)
+	if g == nil {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+	allowed, length := true, -1
+	for _, rule := range g.rules {
+		if !rule.match(path) {
+			continue
+		}
+		if l := len(rule.pattern); l > length || (l == length && rule.allow) {
+			allowed, length = rule.allow, l
+		}
+	}
+	return allowed
+}
+
+func (self *Robots) CrawlDelay(agent string) time.Duration {
+	if g := self.group(agent); g != nil {
+		return g.crawlDelay
+	}
+	return 0
+}
+
+type robotsEntry struct {
+	robots *Robots
+	expire time.Time
+	ready  chan struct{}
+}
+
+// RobotsCache caches robots.txt by scheme and host
+type RobotsCache struct {
+	sync.Mutex
+	items map[string]*robotsEntry
+}
+
+func NewRobotsCache() *RobotsCache {
+	return &RobotsCache{items: make(map[string]*robotsEntry)}
+}
+
+// Get returns the robots.txt of the site of u, concurrent calls for the same
+// site wait for one fetch
+func (self *RobotsCache) Get(u *url.URL) *Robots {
+	key := u.Scheme + "://" + u.Host
+	self.Lock()
+	entry, ok := self.items[key]
+	if ok {
+		select {
+		case <-entry.ready:
+			ok = time.Now().Before(entry.expire)
+		default: // fetching by others
+		}
+	}
+	if !ok {
+		if len(self.items) >= maxRobotsHosts {
+			self.sweep()
+		}
+		entry = &robotsEntry{ready: make(chan struct{})}
+		self.items[key] = entry
+		self.Unlock()
+		entry.robots, entry.expire = fetchRobots(key + "/robots.txt")
+		close(entry.ready)
+		return entry.robots
+	}
+	self.Unlock()
+	<-entry.ready
+	return entry.robots
+}
+
+// sweep removes expired entries, and then arbitrary fetched ones if the
+// cache is still full, must be called with the lock held
+func (self *RobotsCache) sweep() {
+	now := time.Now()
+	var fetched []string
+	for key, entry := range self.items {
+		select {
+		case <-entry.ready:
+			if now.Before(entry.expire) {
+				fetched = append(fetched, key)
+			} else {
+				delete(self.items, key)
+			}
+		default:
+		}
+	}
+	for i := 0; len(self.items) >= maxRobotsHosts && i < len(fetched); i++ {
+		delete(self.items, fetched[i])
+	}
+}
+
+func fetchRobots(robotsUrl string) (*Robots, time.Time) {
+	resp, err := httpClient.Get(robotsUrl)
+	if err != nil {
+		return &Robots{unavailable: true}, time.Now().Add(robotsErrorTTL)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return &Robots{unavailable: true}, time.Now().Add(robotsErrorTTL)
+	case resp.StatusCode >= 400:
+		// no robots.txt, everything is allowed
+		return &Robots{}, time.Now().Add(robotsTTL)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+	if err != nil {
+		return &Robots{unavailable: true}, time.Now().Add(robotsErrorTTL)
+	}
+	return ParseRobots(string(data)), time.Now().Add(robotsTTL)
+}
@@ -0,0 +1,100 @@
+package crawler
+
+import (
+	"fmt"
+	"github.com/crawlerclub/x/types"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	content := `# robots.txt
+User-agent: *
+Disallow: /nForum/user
+Disallow: /*.php$
+Allow: /nForum/user/query
+Crawl-delay: 2
+
+User-agent: BadBot
+User-agent: crawlerclub
+Disallow: /
+
+Sitemap: http://example.com/s1.xml
+sitemap:http://example.com/s2.xml
+`
+	robots := ParseRobots(content)
+	if !reflect.DeepEqual(robots.Sitemaps, []string{"http://example.com/s1.xml", "http://example.com/s2.xml"}) {
+		t.Error("unexpected sitemaps: ", robots.Sitemaps)
+	}
+	var testcases = []struct {
+		agent string
+		path  string
+		allow bool
+	}{
+		{"Mozilla/5.0", "/nForum/board/Universal", true},
+		{"Mozilla/5.0", "/nForum/user/info", false},
+		{"Mozilla/5.0", "/nForum/user/query/abc", true},
+		{"Mozilla/5.0", "/index.php", false},
+		{"Mozilla/5.0", "/index.php?a=1", true},
+		{"crawlerclub/1.0", "/nForum/board/Universal", false},
+		{"badbot", "/", false},
+	}
+	for _, c := range testcases {
+		if res := robots.Allowed(c.agent, c.path); res != c.allow {
+			t.Error(c.agent, c.path, res, "!=", c.allow)
+		}
+	}
+	if d := robots.CrawlDelay("Mozilla/5.0"); d != 2*time.Second {
+		t.Error("unexpected crawl delay: ", d)
+	}
+	if d := robots.CrawlDelay("crawlerclub"); d != 0 {
+		t.Error("unexpected crawl delay: ", d)
+	}
+}
+
+func TestRobotsCache(t *testing.T) {
+	down := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer server.Close()
+	defer func(r *RobotsCache) { DefaultRobots = r }(DefaultRobots)
+	DefaultRobots = NewRobotsCache()
+
+	c := &Crawler{Conf: &types.CrawlerConf{Politeness: types.PolitenessConf{ObeyRobots: true}}}
+	if _, err := c.waitPoliteness(server.URL + "/public"); err != ErrRobotsUnavailable {
+		t.Errorf("got %v, want ErrRobotsUnavailable", err)
+	}
+	// fetched again after the entry expires
+	down = false
+	u, _ := url.Parse(server.URL)
+	DefaultRobots.items[u.Scheme+"://"+u.Host].expire = time.Now()
+	release, err := c.waitPoliteness(server.URL + "/public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err = c.waitPoliteness(server.URL + "/private"); err != ErrDisallowed {
+		t.Errorf("got %v, want ErrDisallowed", err)
+	}
+
+	cache := NewRobotsCache()
+	ready := make(chan struct{})
+	close(ready)
+	for i := 0; i < maxRobotsHosts; i++ {
+		cache.items[fmt.Sprint(i)] = &robotsEntry{robots: &Robots{}, ready: ready,
+			expire: time.Now().Add(time.Duration(i%2) * time.Hour)}
+	}
+	cache.Get(u)
+	if n := len(cache.items); n != maxRobotsHosts/2+1 {
+		t.Errorf("got %d entries after sweeping, want %d", n, maxRobotsHosts/2+1)
+	}
+}
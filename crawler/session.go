@@ -0,0 +1,356 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/crawlerclub/x/types"
+	"github.com/golang/glog"
+	"github.com/robertkrimen/otto"
+	"golang.org/x/net/publicsuffix"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLoginFailed = errors.New("crawler/session.go login failed")
+	ErrLoggedOut   = errors.New("crawler/session.go still logged out after login")
+)
+
+const (
+	// a failed login is not tried again within loginRetryInterval
+	loginRetryInterval = time.Minute
+	// cookies refreshed by max-age are not saved again if their expiry
+	// moves less than it
+	cookieExpirySlack = time.Minute
+)
+
+// SessionStore persists the cookies of sessions, e.g. store.LevelStore
+type SessionStore interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+}
+
+type sessionCookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain"`
+	Path     string    `json:"path"`
+	Expires  time.Time `json:"expires"` // zero for session cookies
+	Secure   bool      `json:"secure"`
+	HostOnly bool      `json:"host_only"`
+}
+
+func (self *sessionCookie) key() string {
+	return self.Domain + ";" + self.Path + ";" + self.Name
+}
+
+// same reports whether o only refreshes self, which is not saved again
+func (self *sessionCookie) same(o *sessionCookie) bool {
+	d := self.Expires.Sub(o.Expires)
+	return self.Value == o.Value && self.Secure == o.Secure && self.HostOnly == o.HostOnly &&
+		self.Expires.IsZero() == o.Expires.IsZero() && d < cookieExpirySlack && d > -cookieExpirySlack
+}
+
+func (self *sessionCookie) match(u *url.URL, now time.Time) bool {
+	if !self.Expires.IsZero() && !self.Expires.After(now) {
+		return false
+	}
+	if self.Secure && u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host != self.Domain &&
+		(self.HostOnly || !strings.HasSuffix(host, "."+self.Domain)) {
+		return false
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	return path == self.Path || (strings.HasPrefix(path, self.Path) &&
+		(strings.HasSuffix(self.Path, "/") || path[len(self.Path)] == '/'))
+}
+
+// Session is the cookie jar of a crawler logged in by its login conf, the
+// cookies are saved to store on changes and loaded by NewSession
+type Session struct {
+	sync.RWMutex
+	name    string
+	conf    *types.LoginConf
+	store   SessionStore
+	cookies map[string]*sessionCookie
+
+	loggedOut *regexp.Regexp
+	success   *regexp.Regexp
+
+	// held while logging in
+	loginLock sync.Mutex
+	// increased on each successful login, 0 if never logged in
+	gen       int
+	lastTry   time.Time
+	lastError error
+}
+
+// NewSession creates the session of crawler name, store may be nil
+func NewSession(name string, conf *types.LoginConf, store SessionStore) (*Session, error) {
+	s := &Session{name: name, conf: conf, store: store,
+		cookies: make(map[string]*sessionCookie)}
+	var err error
+	if s.loggedOut, err = regexp.Compile(conf.LoggedOutPattern); err != nil {
+		return nil, err
+	}
+	if s.success, err = regexp.Compile(conf.SuccessPattern); err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return s, nil
+	}
+	value, err := store.Get(name)
+	if err != nil || value == nil {
+		return s, nil
+	}
+	var cookies []*sessionCookie
+	if err = json.Unmarshal(value, &cookies); err != nil {
+		glog.Error(err)
+		return s, nil
+	}
+	for _, c := range cookies {
+		s.cookies[c.key()] = c
+	}
+	if len(s.cookies) > 0 {
+		// logged in before restarting
+		s.gen = 1
+	}
+	return s, nil
+}
+
+// SetCookies implements http.CookieJar, the cookies are saved if they change
+func (self *Session) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	self.Lock()
+	defer self.Unlock()
+	now := time.Now()
+	changed := false
+	for _, c := range cookies {
+		sc := &sessionCookie{Name: c.Name, Value: c.Value, Secure: c.Secure,
+			Domain: strings.ToLower(strings.TrimPrefix(c.Domain, ".")), Path: c.Path}
+		host := strings.ToLower(u.Hostname())
+		if sc.Domain == "" {
+			sc.Domain, sc.HostOnly = host, true
+		} else if host != sc.Domain && !strings.HasSuffix(host, "."+sc.Domain) {
+			continue
+		} else if suffix, _ := publicsuffix.PublicSuffix(sc.Domain); suffix == sc.Domain {
+			// e.g. domain=com or domain=co.uk, only accepted from the host itself
+			if host != sc.Domain {
+				continue
+			}
+			sc.HostOnly = true
+		}
+		if !strings.HasPrefix(sc.Path, "/") {
+			// the directory of the request path
+			sc.Path = "/"
+			if i := strings.LastIndex(u.Path, "/"); i > 0 {
+				sc.Path = u.Path[:i]
+			}
+		}
+		switch {
+		case c.MaxAge < 0:
+			sc.Expires = now
+		case c.MaxAge > 0:
+			sc.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			sc.Expires = c.Expires
+		}
+		old, ok := self.cookies[sc.key()]
+		if !sc.Expires.IsZero() && !sc.Expires.After(now) {
+			if ok {
+				delete(self.cookies, sc.key())
+				changed = true
+			}
+		} else {
+			self.cookies[sc.key()] = sc
+			changed = changed || !ok || !old.same(sc)
+		}
+	}
+	if changed {
+		self.save()
+	}
+}
+
+// Cookies implements http.CookieJar
+func (self *Session) Cookies(u *url.URL) []*http.Cookie {
+	self.RLock()
+	defer self.RUnlock()
+	var ret []*http.Cookie
+	now := time.Now()
+	for _, c := range self.cookies {
+		if c.match(u, now) {
+			ret = append(ret, &http.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	return ret
+}
+
+// save must be called with the lock held
+func (self *Session) save() {
+	if self.store == nil {
+		return
+	}
+	cookies := make([]*sessionCookie, 0, len(self.cookies))
+	for _, c := range self.cookies {
+		cookies = append(cookies, c)
+	}
+	value, err := json.Marshal(cookies)
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	if err = self.store.Put(self.name, value); err != nil {
+		glog.Error(err)
+	}
+}
+
+// LoggedOut reports whether page is the one shown to visitors not logged in
+func (self *Session) LoggedOut(page string) bool {
+	return self.conf.LoggedOutPattern != "" && self.loggedOut.MatchString(page)
+}
+
+// Ensure logs in if the session has never logged in, it returns the
+// generation of the cookies to be passed to Relogin
+func (self *Session) Ensure(fetch *types.FetchConf) (int, error) {
+	self.loginLock.Lock()
+	defer self.loginLock.Unlock()
+	if self.gen == 0 {
+		if err := self.login(fetch); err != nil {
+			return 0, err
+		}
+	}
+	return self.gen, nil
+}
+
+// Relogin logs in again unless the cookies of gen are replaced by a login of
+// another worker already
+func (self *Session) Relogin(fetch *types.FetchConf, gen int) error {
+	self.loginLock.Lock()
+	defer self.loginLock.Unlock()
+	if gen != self.gen {
+		return nil
+	}
+	return self.login(fetch)
+}
+
+// login must be called with loginLock held
+func (self *Session) login(fetch *types.FetchConf) error {
+	if self.lastError != nil && time.Since(self.lastTry) < loginRetryInterval {
+		return self.lastError
+	}
+	glog.Info(self.name, " login by ", self.conf.LoginType)
+	self.Lock()
+	self.cookies = make(map[string]*sessionCookie)
+	self.Unlock()
+	self.lastTry = time.Now()
+	var page string
+	var err error
+	if self.conf.LoginType == "js" {
+		page, err = self.jsLogin(fetch)
+	} else {
+		page, err = self.formLogin(fetch)
+	}
+	if err == nil && !self.success.MatchString(page) {
+		err = ErrLoginFailed
+	}
+	self.lastError = err
+	if err != nil {
+		glog.Error(self.name, " login failed: ", err)
+		return err
+	}
+	self.gen++
+	return nil
+}
+
+func (self *Session) formLogin(fetch *types.FetchConf) (string, error) {
+	form := url.Values{}
+	for k, v := range self.conf.Form {
+		form.Set(k, v)
+	}
+	// the crawler keeps the proxy of the host in the pool
+	task := &types.Task{CrawlerName: self.name, Url: self.conf.Url}
+	task.SetData(&types.TaskData{Body: form.Encode()})
+	conf := fetch.Merge(&types.FetchConf{Method: "POST",
+		BodyType: "application/x-www-form-urlencoded"})
+	resp, err := Fetch(conf, task, self)
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, nil
+}
+
+// jsLogin runs the js of conf, the page of its last request is returned
+func (self *Session) jsLogin(fetch *types.FetchConf) (string, error) {
+	var page string
+	runtime := otto.New()
+	err := runtime.Set("request", func(call otto.FunctionCall) otto.Value {
+		var args [3]string
+		for i := range args {
+			if arg := call.Argument(i); arg.IsDefined() {
+				args[i] = arg.String()
+			}
+		}
+		task := &types.Task{CrawlerName: self.name, Url: args[1]}
+		task.SetData(&types.TaskData{Body: args[2]})
+		resp, err := Fetch(fetch.Merge(&types.FetchConf{Method: args[0]}), task, self)
+		if resp == nil {
+			panic(call.Otto.MakeCustomError("RequestError", err.Error()))
+		}
+		page = resp.Text
+		v, err := call.Otto.ToValue(map[string]interface{}{
+			"status": resp.StatusCode,
+			"url":    resp.Url,
+			"text":   resp.Text,
+		})
+		if err != nil {
+			panic(call.Otto.MakeCustomError("RequestError", err.Error()))
+		}
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+	v, err := runtime.Run(self.conf.Js)
+	if err != nil {
+		return "", err
+	}
+	if ok, e := v.ToBoolean(); e == nil && v.IsBoolean() && !ok {
+		return "", ErrLoginFailed
+	}
+	return page, nil
+}
+
+// fetch downloads task in the session of the crawler if it logs in, it logs
+// in before the first download and again when a page looks logged out
+func (self *Crawler) fetch(conf *types.FetchConf, task *types.Task) (*FetchResponse, error) {
+	s := self.Session
+	if s == nil {
+		return Fetch(conf, task, nil)
+	}
+	gen, err := s.Ensure(&self.Conf.Fetch)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := Fetch(conf, task, s)
+	if err != nil || !s.LoggedOut(resp.Text) {
+		return resp, err
+	}
+	glog.Info(self.Conf.CrawlerName, " logged out at ", task.Url)
+	if err = s.Relogin(&self.Conf.Fetch, gen); err != nil {
+		return nil, err
+	}
+	resp, err = Fetch(conf, task, s)
+	if err == nil && s.LoggedOut(resp.Text) {
+		return resp, ErrLoggedOut
+	}
+	return resp, err
+}
@@ -0,0 +1,214 @@
+package crawler
+
+import (
+	"fmt"
+	"github.com/crawlerclub/x/types"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+type memStore struct {
+	sync.Mutex
+	m map[string][]byte
+}
+
+func (self *memStore) Get(key string) ([]byte, error) {
+	self.Lock()
+	defer self.Unlock()
+	return self.m[key], nil
+}
+
+func (self *memStore) Put(key string, value []byte) error {
+	self.Lock()
+	defer self.Unlock()
+	self.m[key] = value
+	return nil
+}
+
+// loginServer accepts user/pass with the token of /login, a login
+// invalidates the sessions before it
+type loginServer struct {
+	sync.Mutex
+	sid    int
+	posts  int
+	logins int
+}
+
+func (self *loginServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.Lock()
+	defer self.Unlock()
+	switch r.URL.Path {
+	case "/login":
+		if r.Method == "GET" {
+			http.SetCookie(w, &http.Cookie{Name: "token", Value: "t1", Path: "/"})
+			fmt.Fprint(w, `<input name="token" value="t1">`)
+			return
+		}
+		self.posts++
+		if r.FormValue("user") != "u" || r.FormValue("pass") != "p" {
+			fmt.Fprint(w, "wrong password")
+			return
+		}
+		if r.FormValue("token") != "" {
+			if c, err := r.Cookie("token"); err != nil || c.Value != r.FormValue("token") {
+				fmt.Fprint(w, "bad token")
+				return
+			}
+		}
+		self.sid++
+		self.logins++
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: fmt.Sprint(self.sid), Path: "/"})
+		http.Redirect(w, r, "/welcome", http.StatusFound)
+	case "/welcome":
+		fmt.Fprint(w, "welcome")
+	default:
+		if c, err := r.Cookie("sid"); err != nil || c.Value != fmt.Sprint(self.sid) {
+			fmt.Fprint(w, "please login")
+			return
+		}
+		fmt.Fprint(w, "content of ", r.URL.Path)
+	}
+}
+
+func TestSession(t *testing.T) {
+	ls := &loginServer{}
+	server := httptest.NewServer(ls)
+	defer server.Close()
+
+	login := &types.LoginConf{
+		LoginType:        "form",
+		Url:              server.URL + "/login",
+		Form:             map[string]string{"user": "u", "pass": "p"},
+		SuccessPattern:   "welcome",
+		LoggedOutPattern: "please login",
+	}
+	store := &memStore{m: make(map[string][]byte)}
+	newCrawler := func() *Crawler {
+		s, err := NewSession("test", login, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Crawler{Conf: &types.CrawlerConf{CrawlerName: "test"}, Session: s}
+	}
+	fetch := func(c *Crawler, path string) string {
+		resp, err := c.fetch(&c.Conf.Fetch, &types.Task{Url: server.URL + path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Text
+	}
+
+	c := newCrawler()
+	if text := fetch(c, "/a"); text != "content of /a" || ls.logins != 1 {
+		t.Errorf("got %s after %d logins", text, ls.logins)
+	}
+	fetch(c, "/b")
+	if ls.logins != 1 {
+		t.Errorf("logged in %d times, want 1", ls.logins)
+	}
+
+	// cookies are loaded from store
+	c = newCrawler()
+	if text := fetch(c, "/c"); text != "content of /c" || ls.logins != 1 {
+		t.Errorf("got %s after %d logins", text, ls.logins)
+	}
+
+	// logged out by the server
+	ls.Lock()
+	ls.sid++
+	ls.Unlock()
+	if text := fetch(c, "/d"); text != "content of /d" || ls.logins != 2 {
+		t.Errorf("got %s after %d logins", text, ls.logins)
+	}
+
+	// js login with the token of the login page
+	js := &types.LoginConf{
+		LoginType: "js",
+		Js: `var page = request("GET", "` + server.URL + `/login");
+			var token = page.text.match(/value="(\w+)"/)[1];
+			var resp = request("POST", "` + server.URL + `/login",
+				"user=u&pass=p&token=" + token);
+			resp.text == "welcome";`,
+		LoggedOutPattern: "please login",
+	}
+	s, err := NewSession("js", js, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = &Crawler{Conf: &types.CrawlerConf{CrawlerName: "js"}, Session: s}
+	if text := fetch(c, "/e"); text != "content of /e" || ls.logins != 3 {
+		t.Errorf("got %s after %d logins", text, ls.logins)
+	}
+
+	// failed logins are not retried at once
+	login.Form = map[string]string{"user": "u", "pass": "x"}
+	s, _ = NewSession("wrong", login, nil)
+	c = &Crawler{Conf: &types.CrawlerConf{CrawlerName: "wrong"}, Session: s}
+	posts := ls.posts
+	for i := 0; i < 2; i++ {
+		if _, err = c.fetch(&c.Conf.Fetch, &types.Task{Url: server.URL + "/f"}); err != ErrLoginFailed {
+			t.Errorf("got %v, want ErrLoginFailed", err)
+		}
+	}
+	if ls.posts != posts+1 {
+		t.Errorf("tried to login %d times, want 1", ls.posts-posts)
+	}
+}
+
+type countStore struct {
+	memStore
+	puts int
+}
+
+func (self *countStore) Put(key string, value []byte) error {
+	self.puts++
+	return self.memStore.Put(key, value)
+}
+
+func TestSessionSetCookies(t *testing.T) {
+	store := &countStore{memStore: memStore{m: make(map[string][]byte)}}
+	s, err := NewSession("test", &types.LoginConf{}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://www.example.co.uk/a/b")
+	set := func(cookies ...*http.Cookie) {
+		s.SetCookies(u, cookies)
+	}
+	set(&http.Cookie{Name: "sid", Value: "1", MaxAge: 3600},
+		&http.Cookie{Name: "site", Value: "1", Domain: ".example.co.uk", Path: "/"},
+		&http.Cookie{Name: "tld", Value: "1", Domain: ".co.uk"},
+		&http.Cookie{Name: "bare", Value: "1", Domain: "uk"},
+		&http.Cookie{Name: "other", Value: "1", Domain: "other.co.uk"})
+	got := make(map[string]bool)
+	for _, c := range s.Cookies(&url.URL{Scheme: "http", Host: "img.example.co.uk", Path: "/"}) {
+		got[c.Name] = true
+	}
+	if len(got) != 1 || !got["site"] {
+		t.Errorf("got cookies %v of img.example.co.uk, want site", got)
+	}
+	if len(s.Cookies(u)) != 2 || store.puts != 1 {
+		t.Errorf("got %d cookies and %d puts, want 2 and 1", len(s.Cookies(u)), store.puts)
+	}
+
+	// refreshed by max-age
+	set(&http.Cookie{Name: "sid", Value: "1", MaxAge: 3600})
+	if store.puts != 1 {
+		t.Error("saved cookies not changed")
+	}
+	set(&http.Cookie{Name: "sid", Value: "2", MaxAge: 3600})
+	if store.puts != 2 {
+		t.Error("changed cookies not saved")
+	}
+	set(&http.Cookie{Name: "gone", MaxAge: -1})
+	if store.puts != 2 {
+		t.Error("saved cookies not changed")
+	}
+	set(&http.Cookie{Name: "sid", MaxAge: -1})
+	if store.puts != 3 || len(s.Cookies(u)) != 1 {
+		t.Error("deleted cookie not saved")
+	}
+}
@@ -0,0 +1,184 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSitemapTooLarge = errors.New("crawler/sitemap.go sitemap too large")
+)
+
+const (
+	// sitemaps are limited to 50MB uncompressed by the protocol
+	maxSitemapSize  = 50 * 1024 * 1024
+	maxSitemapDepth = 3
+)
+
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+type SitemapUrl struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+type sitemapXml struct {
+	Urls     []SitemapUrl `xml:"url"`
+	Sitemaps []SitemapUrl `xml:"sitemap"`
+}
+
+// fetchBytes downloads rawurl and decompresses it if it is gzipped
+func fetchBytes(rawurl string) ([]byte, error) {
+	resp, err := httpClient.Get(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s: %s", rawurl, resp.Status)
+	}
+	br := bufio.NewReader(resp.Body)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxSitemapSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSitemapSize {
+		return nil, ErrSitemapTooLarge
+	}
+	return data, nil
+}
+
+// ParseSitemap parses sitemap, sitemap index and plain text sitemap
+func ParseSitemap(data []byte) (urls []SitemapUrl, sitemaps []SitemapUrl, err error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("<")) {
+		// text sitemap, one url per line
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				urls = append(urls, SitemapUrl{Loc: line})
+			}
+		}
+		return urls, nil, nil
+	}
+	var s sitemapXml
+	if err = xml.Unmarshal(data, &s); err != nil {
+		return nil, nil, err
+	}
+	for i, _ := range s.Urls {
+		s.Urls[i].Loc = strings.TrimSpace(s.Urls[i].Loc)
+		s.Urls[i].LastMod = strings.TrimSpace(s.Urls[i].LastMod)
+	}
+	for i, _ := range s.Sitemaps {
+		s.Sitemaps[i].Loc = strings.TrimSpace(s.Sitemaps[i].Loc)
+	}
+	return s.Urls, s.Sitemaps, nil
+}
+
+// sitemapEntries returns the sitemap urls declared in conf and in robots.txt
+// of the start_urls hosts
+func (self *Crawler) sitemapEntries() []string {
+	conf := &self.Conf.Sitemap
+	entries := append([]string{}, conf.SitemapUrls...)
+	if !conf.Robots {
+		return entries
+	}
+	hosts := make(map[string]bool)
+	for _, startUrl := range self.Conf.StartUrls {
+		u, err := url.Parse(startUrl)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		if hosts[u.Host] {
+			continue
+		}
+		hosts[u.Host] = true
+		entries = append(entries, DefaultRobots.Get(u).Sitemaps...)
+	}
+	return entries
+}
+
+// DiscoverSitemaps walks all the sitemaps of the crawler, and calls fn with
+// every url and the parser_name its matched rule maps to
+func (self *Crawler) DiscoverSitemaps(fn func(u SitemapUrl, parserName string) error) error {
+	if self.Conf == nil {
+		return ErrEmptyCrawlerConf
+	}
+	var res []*regexp.Regexp
+	for _, rule := range self.Conf.Sitemap.Rules {
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return err
+		}
+		res = append(res, re)
+	}
+	parserName := func(loc string) string {
+		if len(res) == 0 {
+			return self.Conf.StartParserName
+		}
+		for i, re := range res {
+			if re.MatchString(loc) {
+				return self.Conf.Sitemap.Rules[i].ParserName
+			}
+		}
+		return ""
+	}
+
+	visited := make(map[string]bool)
+	var walk func(sitemap string, depth int) error
+	walk = func(sitemap string, depth int) error {
+		if visited[sitemap] || depth > maxSitemapDepth {
+			return nil
+		}
+		visited[sitemap] = true
+		data, err := fetchBytes(sitemap)
+		if err != nil {
+			glog.Error(err)
+			return nil
+		}
+		urls, sitemaps, err := ParseSitemap(data)
+		if err != nil {
+			glog.Error(sitemap, ": ", err)
+			return nil
+		}
+		for _, u := range urls {
+			if name := parserName(u.Loc); name != "" {
+				if err = fn(u, name); err != nil {
+					return err
+				}
+			}
+		}
+		for _, s := range sitemaps {
+			if err = walk(s.Loc, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, sitemap := range self.sitemapEntries() {
+		if err := walk(sitemap, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
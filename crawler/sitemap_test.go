@@ -0,0 +1,52 @@
+package crawler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSitemap(t *testing.T) {
+	index := `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc> http://example.com/sitemap1.xml.gz </loc></sitemap>
+</sitemapindex>`
+	urls, sitemaps, err := ParseSitemap([]byte(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 0 || len(sitemaps) != 1 || sitemaps[0].Loc != "http://example.com/sitemap1.xml.gz" {
+		t.Error("unexpected result: ", urls, sitemaps)
+	}
+
+	urlset := `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/a</loc><lastmod>2017-06-01</lastmod></url>
+  <url><loc>http://example.com/b</loc></url>
+</urlset>`
+	urls, sitemaps, err = ParseSitemap([]byte(urlset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SitemapUrl{{"http://example.com/a", "2017-06-01"}, {"http://example.com/b", ""}}
+	if !reflect.DeepEqual(urls, want) || len(sitemaps) != 0 {
+		t.Error("unexpected result: ", urls, sitemaps)
+	}
+
+	urls, _, _ = ParseSitemap([]byte("http://example.com/a\n\nhttp://example.com/b\n"))
+	if len(urls) != 2 || urls[1].Loc != "http://example.com/b" {
+		t.Error("unexpected result: ", urls)
+	}
+}
+
+func TestRobotsSitemaps(t *testing.T) {
+	robots := "Sitemap: http://example.com/s0.xml # before any group\n" +
+		"User-agent: *\nDisallow: /admin\nSitemap: http://example.com/s1.xml\n" +
+		"sitemap:http://example.com/s2.xml\nSitemap:\n"
+	res := ParseRobots(robots).Sitemaps
+	want := []string{"http://example.com/s0.xml", "http://example.com/s1.xml", "http://example.com/s2.xml"}
+	if !reflect.DeepEqual(res, want) {
+		t.Error("unexpected result: ", res)
+	}
+	if res = ParseRobots("User-agent: *\nDisallow:\n").Sitemaps; len(res) != 0 {
+		t.Error("unexpected result: ", res)
+	}
+}
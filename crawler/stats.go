@@ -0,0 +1,111 @@
+package crawler
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stats are the counters of a crawler, they are persisted by controller
+type Stats struct {
+	sync.Mutex
+	Pages       int64            `json:"pages"`
+	Bytes       int64            `json:"bytes"`
+	Status      map[string]int64 `json:"status"` // status code or "error" to count
+	ParseErrors int64            `json:"parse_errors"`
+	Items       int64            `json:"items"`
+	Tasks       int64            `json:"tasks"`   // enqueued
+	Latency     int64            `json:"latency"` // total milliseconds of downloading
+}
+
+// StatsSnapshot is a copy of Stats with the derived values
+type StatsSnapshot struct {
+	CrawlerName string           `json:"crawler_name"`
+	Pages       int64            `json:"pages"`
+	Bytes       int64            `json:"bytes"`
+	Status      map[string]int64 `json:"status"`
+	ParseErrors int64            `json:"parse_errors"`
+	Items       int64            `json:"items"`
+	Tasks       int64            `json:"tasks"`
+	QueueDepth  uint64           `json:"queue_depth"`
+	AvgLatency  int64            `json:"avg_latency"` // milliseconds
+	Running     bool             `json:"running"`
+}
+
+func NewStats() *Stats {
+	return &Stats{Status: make(map[string]int64)}
+}
+
+// AddFetch counts a download, status is 0 if it failed
+func (self *Stats) AddFetch(status int, bytes int, latency time.Duration) {
+	if self == nil {
+		return
+	}
+	key := "error"
+	if status > 0 {
+		key = strconv.Itoa(status)
+	}
+	self.Lock()
+	defer self.Unlock()
+	if self.Status == nil {
+		self.Status = make(map[string]int64)
+	}
+	self.Pages++
+	self.Bytes += int64(bytes)
+	self.Status[key]++
+	self.Latency += int64(latency / time.Millisecond)
+}
+
+func (self *Stats) AddParseError() {
+	if self == nil {
+		return
+	}
+	self.Lock()
+	defer self.Unlock()
+	self.ParseErrors++
+}
+
+func (self *Stats) AddItems(n int) {
+	if self == nil {
+		return
+	}
+	self.Lock()
+	defer self.Unlock()
+	self.Items += int64(n)
+}
+
+func (self *Stats) AddTasks(n int) {
+	if self == nil {
+		return
+	}
+	self.Lock()
+	defer self.Unlock()
+	self.Tasks += int64(n)
+}
+
+func (self *Stats) Marshal() ([]byte, error) {
+	self.Lock()
+	defer self.Unlock()
+	return json.Marshal(self)
+}
+
+func (self *Stats) Snapshot() *StatsSnapshot {
+	self.Lock()
+	defer self.Unlock()
+	s := &StatsSnapshot{
+		Pages:       self.Pages,
+		Bytes:       self.Bytes,
+		Status:      make(map[string]int64),
+		ParseErrors: self.ParseErrors,
+		Items:       self.Items,
+		Tasks:       self.Tasks,
+	}
+	for k, v := range self.Status {
+		s.Status[k] = v
+	}
+	if self.Pages > 0 {
+		s.AvgLatency = self.Latency / self.Pages
+	}
+	return s
+}
@@ -0,0 +1,38 @@
+package crawler
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	var empty *Stats
+	empty.AddFetch(200, 10, time.Second) // no effect on nil stats
+
+	s := NewStats()
+	s.AddFetch(200, 1000, 100*time.Millisecond)
+	s.AddFetch(200, 3000, 300*time.Millisecond)
+	s.AddFetch(0, 0, 50*time.Millisecond)
+	s.AddParseError()
+	s.AddItems(3)
+	s.AddTasks(5)
+	b, err := s.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewStats()
+	if err = json.Unmarshal(b, loaded); err != nil {
+		t.Fatal(err)
+	}
+	snap := loaded.Snapshot()
+	if snap.Pages != 3 || snap.Bytes != 4000 || snap.AvgLatency != 150 {
+		t.Error("unexpected snapshot:", snap)
+	}
+	if snap.Status["200"] != 2 || snap.Status["error"] != 1 {
+		t.Error("unexpected status:", snap.Status)
+	}
+	if snap.ParseErrors != 1 || snap.Items != 3 || snap.Tasks != 5 {
+		t.Error("unexpected counters:", snap)
+	}
+}
@@ -1,36 +1,64 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/GeertJohan/go.rice"
 	"github.com/crawlerclub/x/controller"
+	"github.com/crawlerclub/x/crawler"
 	"github.com/crawlerclub/x/handlers"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
 	_ "github.com/mkevac/debugcharts"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
 	_ "net/http/pprof"
+	"time"
 )
 
 var (
 	workerCnt  = flag.Int("wc", 1, "crawler worker count")
 	workingDir = flag.String("dir", "./run", "working dir")
 	serverAddr = flag.String("addr", ":8080", "bind address")
+	timeout    = flag.Duration("shutdown_timeout", 30*time.Second, "time to wait for running tasks on exit")
+	policy     = flag.String("scheduler", controller.PolicyWeighted, "scheduling policy of workers, weighted or drr")
+	proxies    = flag.String("proxies", "", "file or http api of proxies, one per line")
+	proxyCheck = flag.String("proxy_check_url", "", "url requested to check proxies")
+	proxyEvery = flag.Duration("proxy_check_interval", time.Minute, "interval of checking and reloading proxies")
+	proxyBan   = flag.Duration("proxy_ban", 5*time.Minute, "time a failed proxy is banned for")
 )
 
-func Web(ctl *controller.Controller, addr string) {
+// Web starts the http server in background
+func Web(ctl *controller.Controller, addr string) *http.Server {
 	router := mux.NewRouter()
 	crudHandler := handlers.NewCrudCrawlerHandler(ctl)
-	router.Handle("/api/crawler/{action:create|retrieve|update|delete}/{name}",
+	router.Handle("/api/crawler/{action:create|retrieve|update|delete|weight|pause|resume|drain|reset}/{name}",
 		crudHandler)
 	listHandler := handlers.NewListHandler(ctl)
-	router.Handle("/api/list/{type:seed|running|crontab|crawler}", listHandler)
+	router.Handle("/api/list/{type:seed|running|crontab|crawler|disallowed|failed}", listHandler)
+	requeueHandler := handlers.NewRequeueHandler(ctl)
+	router.Handle("/api/requeue/{name}", requeueHandler)
+	seedsHandler := handlers.NewSeedsHandler(ctl)
+	router.Handle("/api/seeds/{name}", seedsHandler)
+	proxiesHandler := handlers.NewProxiesHandler(crawler.DefaultProxyPool)
+	router.Handle("/api/proxies", proxiesHandler)
+	router.Handle("/api/proxies/{action:reload|check}", proxiesHandler)
+	statsHandler := handlers.NewStatsHandler(ctl)
+	router.Handle("/api/stats", statsHandler)
+	router.Handle("/api/stats/{name}", statsHandler)
 	testHandler := handlers.NewTestHandler(ctl)
 	router.Handle("/api/test/{name}", testHandler)
 
 	http.Handle("/api/", router)
+	http.Handle("/metrics", promhttp.Handler())
 	http.Handle("/", http.FileServer(rice.MustFindBox("ui").HTTPBox()))
-	http.ListenAndServe(addr, nil)
+	server := &http.Server{Addr: addr}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			glog.Error(err)
+		}
+	}()
+	return server
 }
 
 func main() {
@@ -39,12 +67,33 @@ func main() {
 	defer glog.Info("crawler exit")
 
 	var ctl controller.Controller
+	ctl.Schduler.Policy = *policy
 	glog.Info("call ctl.Init")
 	err := ctl.Init(*workingDir, *workerCnt)
 	if err != nil {
 		glog.Fatal(err)
 	}
+	ctl.ShutdownTimeout = *timeout
+	if err = ctl.RegisterMetrics(); err != nil {
+		glog.Fatal(err)
+	}
 	defer ctl.Finish()
-	go Web(&ctl, *serverAddr)
+	if *proxies != "" {
+		pool := crawler.DefaultProxyPool
+		pool.Source, pool.CheckUrl = *proxies, *proxyCheck
+		pool.CheckInterval, pool.BanDuration = *proxyEvery, *proxyBan
+		if err = pool.Load(); err != nil {
+			glog.Fatal(err)
+		}
+		exitCh := make(chan struct{})
+		defer close(exitCh)
+		go pool.Run(exitCh)
+	}
+	server := Web(&ctl, *serverAddr)
 	ctl.Run()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = server.Shutdown(ctx); err != nil {
+		glog.Error(err)
+	}
 }
@@ -2,16 +2,23 @@ var $table = $('#table');
 
 function initTable() {
     $table.bootstrapTable({
-        striped: true, height: getHeight(),
+        striped: true, height: getHeight(), detailView: true,
         columns: [ [ 
             { field: 'crawler_name', title: 'CrawlerName', align: 'center', valign: 'middle'},
             { field: 'crawler_type', title: 'Type', align: 'center', valign: 'middle'},
             { field: 'crawler_desp', title: 'Desc', align: 'center', valign: 'middle'},
-            { field: 'weight', title: 'Weight', align: 'center', valign: 'middle' },
+            { field: 'weight', title: 'Weight', align: 'center', valign: 'middle', editable: { type: 'text' } },
             { field: 'author', title: 'Author', align: 'center', valign: 'middle' },
             { field: 'create_time', title: 'CreateTime', align: 'center', valign: 'middle', formatter: timeFormatter },
             { field: 'modify_time', title: 'ModifyTime', align: 'center', valign: 'middle', formatter: timeFormatter },
             { field: 'status', title: 'Status', align: 'center', valign: 'middle' },
+            { field: 'pages', title: 'Pages', align: 'center', valign: 'middle' },
+            { field: 'bytes', title: 'Bytes', align: 'center', valign: 'middle', formatter: bytesFormatter },
+            { field: 'errors', title: 'Errors', align: 'center', valign: 'middle' },
+            { field: 'items', title: 'Items', align: 'center', valign: 'middle' },
+            { field: 'tasks', title: 'Tasks', align: 'center', valign: 'middle' },
+            { field: 'queue_depth', title: 'Queue', align: 'center', valign: 'middle' },
+            { field: 'avg_latency', title: 'Latency(ms)', align: 'center', valign: 'middle' },
             { field: 'operate', title: 'Operate', align: 'center', valign: 'middle', events: operateEvents, formatter: operateFormatter }
         ] ]
     });
@@ -19,14 +26,41 @@ function initTable() {
     setTimeout(function () { $table.bootstrapTable('resetView'); }, 200);
 
     $table.on('expand-row.bs.table', function (e, index, row, $detail) {
-        if (index % 2 == 1) {
-            $detail.html('Loading from ajax request...');
-            $.get('LICENSE', function (res) {
-                $detail.html(res.replace(/\n/g, '<br>'));
-            });
-        }
+        $detail.html('Loading seeds...');
+        $.ajax({
+            url: "/api/seeds/" + row.crawler_name, cache: false, dataType: "json",
+            success: function(seeds) {
+                var $seeds = $('<table></table>');
+                $detail.html($seeds);
+                $seeds.bootstrapTable({
+                    data: seeds || [],
+                    columns: [
+                        { field: 'url', title: 'Seed' },
+                        { field: 'parser_name', title: 'Parser', align: 'center' },
+                        { field: 'last_access_time', title: 'LastRun', align: 'center', formatter: timeFormatter },
+                        { field: 'revisit_cron', title: 'Schedule', align: 'center', formatter: scheduleFormatter },
+                        { field: 'next_run', title: 'NextRun', align: 'center', formatter: timeFormatter }
+                    ]
+                });
+            },
+            error: function(XMLHttpRequest, textStatus, errorThrown) {
+                $detail.html(XMLHttpRequest.responseText);
+            }
+        });
     });
   
+    $table.on('editable-save.bs.table', function (e, field, row, oldValue) {
+        if (field != 'weight') return;
+        $.ajax({
+            url: "/api/crawler/weight/" + row.crawler_name, cache: false,
+            data: { weight: row.weight },
+            error: function(XMLHttpRequest, textStatus, errorThrown) {
+                alert(XMLHttpRequest.responseText);
+                $table.bootstrapTable('refresh');
+            }
+        });
+    });
+
     $table.on('all.bs.table', function (e, name, args) {
         //console.log(name, args);
     });
@@ -34,15 +68,55 @@ function initTable() {
     $(window).resize(function () { $table.bootstrapTable('resetView', { height: getHeight() }); });
 }
 
+// stats are loaded after the crawlers, responses of earlier pages are dropped
+var statsSeq = 0;
+
 function responseHandler(res) {
+    var seq = ++statsSeq;
     $.each(res.rows, function (i, row) {
-        //alert(row.author);
         row.crawler_type = row.conf.crawler_type;
         row.crawler_desp = row.conf.crawler_desp;
+        mergeStats(row, {});
+    });
+    $.ajax({
+        url: "/api/stats", cache: false, dataType: "json",
+        success: function(data) {
+            if (seq != statsSeq) return;
+            var stats = {};
+            $.each(data, function (i, s) { stats[s.crawler_name] = s; });
+            $.each(res.rows, function (i, row) { mergeStats(row, stats[row.crawler_name] || {}); });
+            // keep the scroll position
+            res.fixedScroll = true;
+            $table.bootstrapTable('load', res);
+        }
     });
     return res;
 }
 
+function mergeStats(row, s) {
+    row.pages = s.pages || 0;
+    row.bytes = s.bytes || 0;
+    row.errors = s.parse_errors || 0;
+    $.each(s.status || {}, function (code, n) {
+        // network errors and non 2xx responses
+        if (code.charAt(0) != "2") row.errors += n;
+    });
+    row.items = s.items || 0;
+    row.tasks = s.tasks || 0;
+    row.queue_depth = s.running ? s.queue_depth : "-";
+    row.avg_latency = s.avg_latency || 0;
+}
+
+function bytesFormatter(value, row, index) {
+    var units = ["B", "KB", "MB", "GB", "TB"];
+    var i = 0;
+    while (value >= 1024 && i < units.length - 1) {
+        value /= 1024;
+        i++;
+    }
+    return (i == 0 ? value : value.toFixed(1)) + units[i];
+}
+
 function operateFormatter(value, row, index) {
     return [
         '<a class="test" href="javascript:void(0)" title="Test">',
@@ -51,12 +125,30 @@ function operateFormatter(value, row, index) {
         '<a class="edit" href="javascript:void(0)" title="Edit">',
         '<i class="glyphicon glyphicon-edit"></i>',
         '</a>  ',
+        '<a class="pause" href="javascript:void(0)" title="Pause">',
+        '<i class="glyphicon glyphicon-pause"></i>',
+        '</a>  ',
+        '<a class="resume" href="javascript:void(0)" title="Resume">',
+        '<i class="glyphicon glyphicon-play"></i>',
+        '</a>  ',
+        '<a class="drain" href="javascript:void(0)" title="Drain">',
+        '<i class="glyphicon glyphicon-log-out"></i>',
+        '</a>  ',
+        '<a class="reset" href="javascript:void(0)" title="Reset">',
+        '<i class="glyphicon glyphicon-repeat"></i>',
+        '</a>  ',
         '<a class="remove" href="javascript:void(0)" title="Remove">',
         '<i class="glyphicon glyphicon-remove"></i>',
         '</a>'
     ].join('');
 }
 
+function scheduleFormatter(value, row, index) {
+    if (value) return value;
+    if (row.revisit_interval > 0) return "every " + row.revisit_interval + "s";
+    return "-";
+}
+
 function timeFormatter(value, row, index) {
     if (value <= 0) return "-";
     return new Date(value * 1000).toLocaleString();
@@ -64,9 +156,27 @@ function timeFormatter(value, row, index) {
 
 $('#addnew').click(function () { window.open("/editor/"); });
 
+function crawlerAction(action, name) {
+    $.ajax({
+        url: "/api/crawler/" + action + "/" + name, cache: false,
+        success: function(data) { $table.bootstrapTable('refresh'); },
+        error: function(XMLHttpRequest, textStatus, errorThrown) {
+            $.fn.modalAlert(XMLHttpRequest.responseText, "error");
+        }
+    });
+}
+
 window.operateEvents = {
     'click .test': function (e, value, row, index) { window.open("/test.html?name=" + row.crawler_name); },
     'click .edit': function (e, value, row, index) { window.open("/editor/?name=" + row.crawler_name); },
+    'click .pause': function (e, value, row, index) { crawlerAction("pause", row.crawler_name); },
+    'click .resume': function (e, value, row, index) { crawlerAction("resume", row.crawler_name); },
+    'click .drain': function (e, value, row, index) { crawlerAction("drain", row.crawler_name); },
+    'click .reset': function (e, value, row, index) {
+        if (confirm("Reset " + row.crawler_name + "? Its queue and seeds will be cleared.")) {
+            crawlerAction("reset", row.crawler_name);
+        }
+    },
     'click .remove': function (e, value, row, index) {
         $.ajax({
             url: "/api/crawler/delete/" + row.crawler_name, cache: false,
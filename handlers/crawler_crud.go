@@ -8,6 +8,7 @@ import (
 	"github.com/liuzl/store"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
 type CrudCrawlerHandler struct {
@@ -57,12 +58,42 @@ func (self *CrudCrawlerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 		} else {
 			ok(w)
 		}
+	case "weight":
+		weight, err := strconv.Atoi(r.FormValue("weight"))
+		if err == nil {
+			err = self.ctl.SetWeight(vars["name"], weight)
+		}
+		if err != nil {
+			showError(w, r, err.Error(), 400)
+		} else {
+			ok(w)
+		}
+	case "pause", "resume", "drain", "reset":
+		err := self.changeState(vars["action"], vars["name"])
+		if err != nil {
+			showError(w, r, err.Error(), 500)
+		} else {
+			ok(w)
+		}
 	default:
 		showError(w, r, "unknown action", 400)
 		return
 	}
 }
 
+func (self *CrudCrawlerHandler) changeState(action, name string) error {
+	switch action {
+	case "pause":
+		return self.ctl.Pause(name)
+	case "resume":
+		return self.ctl.Resume(name)
+	case "drain":
+		return self.ctl.Drain(name)
+	default:
+		return self.ctl.Reset(name)
+	}
+}
+
 func (self *CrudCrawlerHandler) saveCrawlerItem(r *http.Request, isNew bool) error {
 	b, err := ioutil.ReadAll(r.Body)
 	if err != nil {
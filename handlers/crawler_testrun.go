@@ -37,6 +37,14 @@ func (self *TestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	worker := crawler.Crawler{Conf: &item.Conf}
+	if item.Conf.Login.Enabled() {
+		// logs in by itself, the cookies of the running crawler are kept
+		worker.Session, err = crawler.NewSession(item.CrawlerName, &item.Conf.Login, nil)
+		if err != nil {
+			showError(w, r, err.Error(), 500)
+			return
+		}
+	}
 	ret, err := worker.Test()
 	if err != nil {
 		showError(w, r, err.Error(), 500)
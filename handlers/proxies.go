@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"github.com/crawlerclub/x/crawler"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// ProxiesHandler returns the usage stats of the proxies of pool, the
+// proxies are loaded again or checked by action reload or check
+type ProxiesHandler struct {
+	pool *crawler.ProxyPool
+}
+
+func NewProxiesHandler(pool *crawler.ProxyPool) *ProxiesHandler {
+	return &ProxiesHandler{pool: pool}
+}
+
+func (self *ProxiesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if self.pool == nil {
+		showError(w, r, "proxy pool is nil", 500)
+		return
+	}
+	switch mux.Vars(r)["action"] {
+	case "":
+		mustEncode(w, self.pool.Stats())
+	case "reload":
+		if self.pool.Source == "" {
+			showError(w, r, "no proxy source", 400)
+			return
+		}
+		if err := self.pool.Load(); err != nil {
+			showError(w, r, err.Error(), 500)
+			return
+		}
+		ok(w)
+	case "check":
+		self.pool.Check()
+		ok(w)
+	default:
+		showError(w, r, "unknown action", 400)
+	}
+}
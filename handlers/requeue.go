@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"github.com/crawlerclub/x/controller"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// RequeueHandler enqueues the failed tasks of a crawler again, the optional
+// url param requeues a single task
+type RequeueHandler struct {
+	ctl *controller.Controller
+}
+
+func NewRequeueHandler(ctl *controller.Controller) *RequeueHandler {
+	return &RequeueHandler{ctl: ctl}
+}
+
+func (self *RequeueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if self.ctl == nil || self.ctl.Stores == nil {
+		showError(w, r, "controller is nil", 500)
+		return
+	}
+	vars := mux.Vars(r)
+	r.ParseForm()
+	count, err := self.ctl.RequeueFailed(vars["name"], r.FormValue("url"))
+	if err != nil {
+		showError(w, r, err.Error(), 500)
+		return
+	}
+	rv := struct {
+		Status string `json:"status"`
+		Count  int    `json:"count"`
+	}{
+		Status: "ok",
+		Count:  count,
+	}
+	mustEncode(w, rv)
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"github.com/crawlerclub/x/controller"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// SeedsHandler returns the seeds of crawler {name} with their next revisits
+type SeedsHandler struct {
+	ctl *controller.Controller
+}
+
+func NewSeedsHandler(ctl *controller.Controller) *SeedsHandler {
+	return &SeedsHandler{ctl: ctl}
+}
+
+func (self *SeedsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if self.ctl == nil || self.ctl.Stores == nil {
+		showError(w, r, "controller is nil", 500)
+		return
+	}
+	seeds, err := self.ctl.Seeds(mux.Vars(r)["name"])
+	if err != nil {
+		showError(w, r, err.Error(), 404)
+		return
+	}
+	mustEncode(w, seeds)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"github.com/crawlerclub/x/controller"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// StatsHandler returns the stats of crawler {name}, or of all crawlers if
+// no name is given
+type StatsHandler struct {
+	ctl *controller.Controller
+}
+
+func NewStatsHandler(ctl *controller.Controller) *StatsHandler {
+	return &StatsHandler{ctl: ctl}
+}
+
+func (self *StatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if self.ctl == nil || self.ctl.Stores == nil {
+		showError(w, r, "controller is nil", 500)
+		return
+	}
+	name, has := mux.Vars(r)["name"]
+	if !has {
+		stats, err := self.ctl.AllStats()
+		if err != nil {
+			showError(w, r, err.Error(), 500)
+			return
+		}
+		mustEncode(w, stats)
+		return
+	}
+	stats, err := self.ctl.GetStats(name)
+	if err != nil {
+		showError(w, r, err.Error(), 404)
+		return
+	}
+	mustEncode(w, stats)
+}
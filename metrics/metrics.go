@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics of crawlers exported at /metrics, queue depth and store sizes are
+// collected by controller on scraping
+var (
+	Downloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawler_downloads_total",
+		Help: "Pages downloaded by crawler and status, status is error for failed downloads.",
+	}, []string{"crawler", "status"})
+
+	DownloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "crawler_download_duration_seconds",
+		Help:    "Time of downloading pages.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"crawler"})
+
+	ParseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "crawler_parse_duration_seconds",
+		Help:    "Time of parsing pages by parser.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+	}, []string{"crawler", "parser"})
+
+	ParseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawler_parse_errors_total",
+		Help: "Pages failed to parse.",
+	}, []string{"crawler", "parser"})
+
+	SchedulerChoices = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawler_scheduler_choices_total",
+		Help: "Times a crawler is chosen by the scheduler.",
+	}, []string{"crawler"})
+
+	TaskRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawler_task_retries_total",
+		Help: "Failed tasks scheduled to retry.",
+	}, []string{"crawler"})
+
+	TaskFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawler_task_failures_total",
+		Help: "Tasks moved to the failed store after exhausting retries.",
+	}, []string{"crawler"})
+
+	SinkErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "crawler_sink_errors_total",
+		Help: "Items failed to save by sink type.",
+	}, []string{"crawler", "sink"})
+)
+
+func init() {
+	prometheus.MustRegister(Downloads, DownloadDuration, ParseDuration, ParseErrors,
+		SchedulerChoices, TaskRetries, TaskFailures, SinkErrors)
+}
@@ -0,0 +1,336 @@
+package parser
+
+import (
+	"errors"
+	"github.com/crawlerclub/x/types"
+	t "github.com/lestrrat/go-libxml2/types"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+func init() {
+	Parsers["content"] = ContentParser{"content parser"}
+}
+
+// ContentParser extracts the main article of a page without any rules, the
+// only item generated has fields: title, content, html, publish_time, author,
+// image and links
+type ContentParser struct {
+	Name string
+}
+
+var (
+	reUnlikely = regexp.MustCompile(`(?i)comment|meta|foot|sidebar|side-|nav|menu|share|related|recommend|ad-|advert|banner|sponsor|copyright|login|popup`)
+	reLikely   = regexp.MustCompile(`(?i)article|body|content|entry|main|page|post|text|blog|story|detail`)
+	// separators of site names in titles, e.g. "title - site" or "title | site"
+	reTitleSep = regexp.MustCompile(`\s+[-|_–—]\s+`)
+	reDate     = regexp.MustCompile(`\d{4}\s*[-/.年]\s*\d{1,2}\s*[-/.月]\s*\d{1,2}(\s*日)?(\s*\d{1,2}:\d{1,2}(:\d{1,2})?)?`)
+)
+
+var blockTags = map[string]bool{
+	"address": true, "article": true, "blockquote": true, "body": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "figcaption": true, "figure": true,
+	"footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "header": true, "li": true, "main": true, "ol": true, "p": true,
+	"pre": true, "section": true, "table": true, "td": true, "th": true,
+	"tr": true, "ul": true,
+}
+
+var tagWeights = map[string]float64{
+	"article": 10, "div": 5, "pre": 3, "td": 3, "blockquote": 3,
+	"address": -3, "ol": -3, "ul": -3, "dl": -3, "dd": -3, "dt": -3, "li": -3,
+	"form": -3, "h1": -5, "h2": -5, "h3": -5, "h4": -5, "h5": -5, "h6": -5, "th": -5,
+}
+
+type contentCandidate struct {
+	node  t.Node
+	score float64
+}
+
+func (parser ContentParser) String() string {
+	return parser.Name
+}
+
+// xpathString returns the text of the first node selected by expr with
+// spaces normalized, like normalize-space() of xpath
+func xpathString(node t.Node, expr string) string {
+	res, err := node.Find(expr)
+	if err != nil {
+		return ""
+	}
+	defer res.Free()
+	nodes := res.NodeList()
+	if len(nodes) == 0 {
+		return ""
+	}
+	return strings.Join(strings.Fields(nodes[0].TextContent()), " ")
+}
+
+// firstString returns the first non empty result of exprs
+func firstString(node t.Node, exprs ...string) string {
+	for _, expr := range exprs {
+		if s := xpathString(node, expr); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func textLen(s string) int {
+	return utf8.RuneCountInString(strings.Join(strings.Fields(s), " "))
+}
+
+func extractTitle(root t.Node) string {
+	if title := firstString(root,
+		`//meta[@property='og:title']/@content`,
+		`//meta[@name='twitter:title']/@content`); title != "" {
+		return title
+	}
+	title := xpathString(root, `//title`)
+	h1 := xpathString(root, `//h1`)
+	if title == "" {
+		return h1
+	}
+	if h1 != "" && strings.Contains(title, h1) {
+		return h1
+	}
+	// strip site name of title, keep the longest part
+	ret := ""
+	for _, part := range reTitleSep.Split(title, -1) {
+		part = strings.TrimSpace(part)
+		if textLen(part) > textLen(ret) {
+			ret = part
+		}
+	}
+	return ret
+}
+
+func extractPublishTime(root t.Node) string {
+	if s := firstString(root,
+		`//meta[@property='article:published_time']/@content`,
+		`//meta[@property='og:article:published_time']/@content`,
+		`//meta[@name='pubdate']/@content`,
+		`//meta[@name='publishdate']/@content`,
+		`//meta[@name='PubDate']/@content`,
+		`//meta[@name='DC.date.issued']/@content`,
+		`//meta[@name='date']/@content`,
+		`//*[@itemprop='datePublished']/@content`,
+		`//*[@itemprop='datePublished']/@datetime`,
+		`//time/@datetime`); s != "" {
+		return s
+	}
+	return reDate.FindString(xpathString(root, `//body`))
+}
+
+func extractAuthor(root t.Node) string {
+	return firstString(root,
+		`//meta[@name='author']/@content`,
+		`//meta[@property='article:author']/@content`,
+		`//*[@rel='author']`,
+		`//*[@itemprop='author']`,
+		`//*[contains(@class, 'author')]`)
+}
+
+func addCandidate(candidates map[uintptr]*contentCandidate, node t.Node, score float64) {
+	c, ok := candidates[node.Pointer()]
+	if !ok {
+		name := strings.ToLower(node.NodeName())
+		c = &contentCandidate{node: node, score: tagWeights[name]}
+		attrs := xpathString(node, `@class`) + " " + xpathString(node, `@id`)
+		if reUnlikely.MatchString(attrs) {
+			c.score -= 25
+		}
+		if reLikely.MatchString(attrs) {
+			c.score += 25
+		}
+		candidates[node.Pointer()] = c
+	}
+	c.score += score
+}
+
+func isElement(node t.Node) bool {
+	name := strings.ToLower(node.NodeName())
+	return name != "" && name != "html" && !strings.HasPrefix(name, "#")
+}
+
+func linkDensity(node t.Node) float64 {
+	total := textLen(node.TextContent())
+	if total == 0 {
+		return 0
+	}
+	res, err := node.Find(`.//a`)
+	if err != nil {
+		return 0
+	}
+	defer res.Free()
+	links := 0
+	for _, a := range res.NodeList() {
+		links += textLen(a.TextContent())
+	}
+	return float64(links) / float64(total)
+}
+
+// extractContentNode scores the parents of paragraphs like readability does,
+// and returns the node with the highest score
+func extractContentNode(root t.Node) t.Node {
+	res, err := root.Find(`//p|//pre|//td`)
+	if err != nil {
+		return nil
+	}
+	defer res.Free()
+	candidates := make(map[uintptr]*contentCandidate)
+	for _, p := range res.NodeList() {
+		text := p.TextContent()
+		length := textLen(text)
+		if length < 25 {
+			continue
+		}
+		score := 1 + float64(strings.Count(text, ",")+strings.Count(text, "，")+strings.Count(text, "。"))
+		if length/100 < 3 {
+			score += float64(length / 100)
+		} else {
+			score += 3
+		}
+		parent, err := p.ParentNode()
+		if err != nil || parent == nil || !isElement(parent) {
+			continue
+		}
+		addCandidate(candidates, parent, score)
+		if grand, err := parent.ParentNode(); err == nil && grand != nil && isElement(grand) {
+			addCandidate(candidates, grand, score/2)
+		}
+	}
+	var best *contentCandidate
+	for _, c := range candidates {
+		c.score *= 1 - linkDensity(c.node)
+		if best == nil || c.score > best.score {
+			best = c
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.node
+}
+
+// blockAncestor returns the pointer of the nearest block level ancestor of node
+func blockAncestor(node t.Node) uintptr {
+	for {
+		parent, err := node.ParentNode()
+		if err != nil || parent == nil {
+			return 0
+		}
+		if blockTags[strings.ToLower(parent.NodeName())] {
+			return parent.Pointer()
+		}
+		node = parent
+	}
+}
+
+// contentText returns the visible text of node, text in different block
+// level elements are separated by new lines
+func contentText(node t.Node) string {
+	res, err := node.Find(`.//text()[not(ancestor::script) and not(ancestor::style) and not(ancestor::noscript)]`)
+	if err != nil {
+		return ""
+	}
+	defer res.Free()
+	var lines []string
+	var line string
+	var lastBlock uintptr
+	for _, n := range res.NodeList() {
+		text := strings.Join(strings.Fields(n.TextContent()), " ")
+		if text == "" {
+			continue
+		}
+		block := blockAncestor(n)
+		if block != lastBlock && line != "" {
+			lines = append(lines, line)
+			line = ""
+		}
+		lastBlock = block
+		if line != "" && !strings.HasSuffix(line, " ") {
+			line += " "
+		}
+		line += text
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func extractLinks(node t.Node, pageUrl string) []string {
+	res, err := node.Find(`.//a/@href`)
+	if err != nil {
+		return nil
+	}
+	defer res.Free()
+	var links []string
+	seen := make(map[string]bool)
+	for _, n := range res.NodeList() {
+		href := strings.TrimSpace(n.TextContent())
+		if href == "" || strings.HasPrefix(href, "#") ||
+			strings.HasPrefix(href, "javascript:") || strings.HasPrefix(href, "mailto:") {
+			continue
+		}
+		u, err := MakeAbsoluteUrl(href, pageUrl)
+		if err != nil || seen[u] {
+			continue
+		}
+		seen[u] = true
+		links = append(links, u)
+	}
+	return links
+}
+
+func (parser ContentParser) Parse(
+	page, pageUrl string,
+	parseConf *types.ParseConf,
+	data *types.TaskData) ([]types.Task, []map[string]interface{}, error) {
+	if parseConf == nil {
+		return nil, nil, errors.New("parse conf is nil")
+	}
+	if len(page) == 0 {
+		return nil, nil, errors.New("page len is 0")
+	}
+
+	root, err := ParseHTMLString(page, "utf-8")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer root.Free()
+
+	item := make(map[string]interface{})
+	item["title"] = extractTitle(root)
+	item["publish_time"] = extractPublishTime(root)
+	item["author"] = extractAuthor(root)
+	image := firstString(root,
+		`//meta[@property='og:image']/@content`,
+		`//meta[@name='twitter:image']/@content`)
+
+	var linksNode t.Node = root
+	if node := extractContentNode(root); node != nil {
+		item["content"] = contentText(node)
+		item["html"] = node.String()
+		if image == "" {
+			image = xpathString(node, `.//img/@src`)
+		}
+		linksNode = node
+	}
+	if image != "" {
+		image, _ = MakeAbsoluteUrl(image, pageUrl)
+	}
+	item["image"] = image
+	item["links"] = extractLinks(linksNode, pageUrl)
+
+	retItems := []map[string]interface{}{item}
+	addDefaultFields(retItems, pageUrl, parseConf)
+	mergeContext(retItems, data)
+	retItems, err = postProcess(retItems, parseConf.PostProcessor, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, retItems, nil
+}
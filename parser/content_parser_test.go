@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"github.com/crawlerclub/x/types"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestContentParser(t *testing.T) {
+	var testcases = []struct {
+		file        string
+		pageUrl     string
+		title       string
+		publishTime string
+		author      string
+		image       string
+		contains    []string
+		excludes    []string
+	}{
+		{
+			file:        "news.html",
+			pageUrl:     "http://news.example.com/tech/rust",
+			title:       "Rust 1.0 released",
+			publishTime: "2015-05-15T10:00:00Z",
+			author:      "Jane Doe",
+			image:       "http://news.example.com/img/rust.png",
+			contains: []string{
+				"the first stable version of the language",
+				"guarantees memory safety without a garbage collector",
+			},
+			excludes: []string{"Related:", "Copyright", "Home"},
+		},
+		{
+			file:        "blog.html",
+			pageUrl:     "http://blog.example.com/2018/03/rename",
+			title:       "Renaming foo_bar to foo_baz",
+			publishTime: "2018-03-02",
+			author:      "john",
+			contains: []string{
+				"I renamed the foo_bar module to foo_baz",
+				"mv foo_bar foo_baz",
+			},
+			excludes: []string{"nice post", "My Blog"},
+		},
+		{
+			file:        "forum.html",
+			pageUrl:     "http://www.newsmth.net/nForum/article/Beijing/1",
+			title:       "北京的秋天",
+			publishTime: "2017年10月08日 09:30:12",
+			contains:    []string{"香山的红叶", "附近的植物园"},
+			excludes:    []string{"旅游"},
+		},
+	}
+	conf := &types.ParseConf{ParserName: "content", NoDefaultFields: true}
+	for _, c := range testcases {
+		page, err := ioutil.ReadFile("testdata/" + c.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tasks, items, err := GetParser("content").Parse(string(page), c.pageUrl, conf, nil)
+		if err != nil {
+			t.Fatal(c.file, err)
+		}
+		if len(tasks) != 0 || len(items) != 1 {
+			t.Fatal(c.file, "unexpected result: ", tasks, items)
+		}
+		item := items[0]
+		for key, want := range map[string]string{"title": c.title,
+			"publish_time": c.publishTime, "author": c.author, "image": c.image} {
+			if got := item[key]; got != want {
+				t.Errorf("%s: got %s %q, want %q", c.file, key, got, want)
+			}
+		}
+		content, _ := item["content"].(string)
+		for _, s := range c.contains {
+			if !strings.Contains(content, s) {
+				t.Errorf("%s: %q not in content %q", c.file, s, content)
+			}
+		}
+		for _, s := range c.excludes {
+			if strings.Contains(content, s) {
+				t.Errorf("%s: %q in content %q", c.file, s, content)
+			}
+		}
+	}
+
+	// links of the content
+	page, _ := ioutil.ReadFile("testdata/news.html")
+	_, items, _ := GetParser("content").Parse(string(page), "http://news.example.com/tech/rust", conf, nil)
+	links, _ := items[0]["links"].([]string)
+	if len(links) != 1 || links[0] != "https://blog.rust-lang.org/" {
+		t.Error("unexpected links: ", links)
+	}
+}
+
+func TestExtractTitle(t *testing.T) {
+	var testcases = []struct {
+		html  string
+		title string
+	}{
+		{"<title>foo_bar</title>", "foo_bar"},
+		{"<title>a|b</title>", "a|b"},
+		{"<title>Site | The long title of page</title>", "The long title of page"},
+		{"<title>The long title of page _ Site</title>", "The long title of page"},
+		{"<title>The long title – Site</title><h1>Other</h1>", "The long title"},
+		{"<title>Site - The title</title><h1>The title</h1>", "The title"},
+		{"<h1> The  title </h1>", "The title"},
+	}
+	for _, c := range testcases {
+		root, err := ParseHTMLString("<html><head>"+c.html+"</head><body></body></html>", "utf-8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if title := extractTitle(root); title != c.title {
+			t.Errorf("%s: got %q, want %q", c.html, title, c.title)
+		}
+		root.Free()
+	}
+}
@@ -0,0 +1,410 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CssToXpath translates a css selector to an xpath relative to the context
+// node. Besides css3 selectors, it supports :contains(text), a leading > for
+// children of the context node, and the pseudo-elements ::text and
+// ::attr(name) to select text nodes and attribute values.
+func CssToXpath(selector string) (string, error) {
+	p := &cssParser{s: strings.TrimSpace(selector)}
+	if p.eof() {
+		return "", p.errorf("empty selector")
+	}
+	var groups []string
+	for {
+		xpath, err := p.parseSelector()
+		if err != nil {
+			return "", err
+		}
+		groups = append(groups, xpath)
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		if p.peek() != ',' {
+			return "", p.errorf("unexpected %q", p.peek())
+		}
+		p.pos++
+	}
+	return strings.Join(groups, " | "), nil
+}
+
+type cssParser struct {
+	s   string
+	pos int
+}
+
+func (p *cssParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid css selector %q at %d: %s", p.s, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *cssParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *cssParser) peek() byte {
+	return p.s[p.pos]
+}
+
+func (p *cssParser) skipSpace() bool {
+	start := p.pos
+	for !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '_' || c >= 0x80
+}
+
+func (p *cssParser) parseIdent() (string, error) {
+	start := p.pos
+	for !p.eof() && isIdentChar(p.peek()) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expect identifier")
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *cssParser) parseString() (string, error) {
+	if p.eof() {
+		return "", p.errorf("expect string")
+	}
+	quote := p.peek()
+	if quote != '"' && quote != '\'' {
+		return p.parseIdent()
+	}
+	end := strings.IndexByte(p.s[p.pos+1:], quote)
+	if end < 0 {
+		return "", p.errorf("unterminated string")
+	}
+	str := p.s[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return str, nil
+}
+
+// parseParens returns the text between the parentheses at current position
+func (p *cssParser) parseParens() (string, error) {
+	if p.eof() || p.peek() != '(' {
+		return "", p.errorf("expect (")
+	}
+	depth := 0
+	var quote byte
+	for i := p.pos; i < len(p.s); i++ {
+		c := p.s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				str := p.s[p.pos+1 : i]
+				p.pos = i + 1
+				return strings.TrimSpace(str), nil
+			}
+		}
+	}
+	return "", p.errorf("missing )")
+}
+
+func (p *cssParser) parseSelector() (string, error) {
+	p.skipSpace()
+	xpath := "."
+	combinator := byte(' ')
+	if !p.eof() && p.peek() == '>' {
+		combinator = '>'
+		p.pos++
+		p.skipSpace()
+	}
+	for {
+		tag, preds, pseudo, err := p.parseCompound()
+		if err != nil {
+			return "", err
+		}
+		switch combinator {
+		case ' ':
+			xpath += "//" + tag + preds
+		case '>':
+			xpath += "/" + tag + preds
+		case '~':
+			xpath += "/following-sibling::" + tag + preds
+		case '+':
+			xpath += "/following-sibling::*[1]/self::" + tag + preds
+		}
+		hasSpace := p.skipSpace()
+		if pseudo != "" {
+			if !p.eof() && p.peek() != ',' {
+				return "", p.errorf("pseudo-element must be the last")
+			}
+			return xpath + pseudo, nil
+		}
+		if p.eof() || p.peek() == ',' {
+			return xpath, nil
+		}
+		switch c := p.peek(); c {
+		case '>', '+', '~':
+			combinator = c
+			p.pos++
+			p.skipSpace()
+		default:
+			if !hasSpace {
+				return "", p.errorf("unexpected %q", c)
+			}
+			combinator = ' '
+		}
+	}
+}
+
+// parseCompound parses a sequence of simple selectors, it returns the tag
+// name, the xpath predicates and the xpath of pseudo-element if any
+func (p *cssParser) parseCompound() (tag, preds, pseudo string, err error) {
+	tag = "*"
+	start := p.pos
+	if !p.eof() && p.peek() == '*' {
+		p.pos++
+	} else if !p.eof() && isIdentChar(p.peek()) {
+		if tag, err = p.parseIdent(); err != nil {
+			return
+		}
+	}
+	for !p.eof() {
+		var pred string
+		switch p.peek() {
+		case '#':
+			p.pos++
+			var id string
+			if id, err = p.parseIdent(); err != nil {
+				return
+			}
+			pred = "@id=" + xpathLiteral(id)
+		case '.':
+			p.pos++
+			var class string
+			if class, err = p.parseIdent(); err != nil {
+				return
+			}
+			pred = containsWord("@class", class)
+		case '[':
+			if pred, err = p.parseAttr(); err != nil {
+				return
+			}
+		case ':':
+			if strings.HasPrefix(p.s[p.pos:], "::") {
+				pseudo, err = p.parsePseudoElement()
+				return
+			}
+			if pred, err = p.parsePseudoClass(); err != nil {
+				return
+			}
+		default:
+			if p.pos == start {
+				err = p.errorf("expect selector")
+			}
+			return
+		}
+		preds += "[" + pred + "]"
+	}
+	if p.pos == start {
+		err = p.errorf("expect selector")
+	}
+	return
+}
+
+func (p *cssParser) parseAttr() (string, error) {
+	p.pos++ // [
+	p.skipSpace()
+	name, err := p.parseIdent()
+	if err != nil {
+		return "", err
+	}
+	attr := "@" + name
+	p.skipSpace()
+	if p.eof() {
+		return "", p.errorf("missing ]")
+	}
+	if p.peek() == ']' {
+		p.pos++
+		return attr, nil
+	}
+	op := ""
+	for _, o := range []string{"=", "~=", "^=", "$=", "*=", "|=", "!="} {
+		if strings.HasPrefix(p.s[p.pos:], o) {
+			op = o
+		}
+	}
+	if op == "" {
+		return "", p.errorf("unknown attribute operator")
+	}
+	p.pos += len(op)
+	p.skipSpace()
+	value, err := p.parseString()
+	if err != nil {
+		return "", err
+	}
+	p.skipSpace()
+	if p.eof() || p.peek() != ']' {
+		return "", p.errorf("missing ]")
+	}
+	p.pos++
+	v := xpathLiteral(value)
+	switch op {
+	case "~=":
+		return containsWord(attr, value), nil
+	case "^=":
+		return fmt.Sprintf("starts-with(%s, %s)", attr, v), nil
+	case "$=":
+		return fmt.Sprintf("substring(%s, string-length(%s)-%d+1)=%s",
+			attr, attr, utf8.RuneCountInString(value), v), nil
+	case "*=":
+		return fmt.Sprintf("contains(%s, %s)", attr, v), nil
+	case "|=":
+		return fmt.Sprintf("%s=%s or starts-with(%s, %s)",
+			attr, v, attr, xpathLiteral(value+"-")), nil
+	case "!=":
+		return fmt.Sprintf("not(%s=%s)", attr, v), nil
+	}
+	return attr + "=" + v, nil
+}
+
+func (p *cssParser) parsePseudoElement() (string, error) {
+	p.pos += 2 // ::
+	name, err := p.parseIdent()
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(name) {
+	case "text":
+		return "/text()", nil
+	case "attr":
+		arg, err := p.parseParens()
+		if err != nil {
+			return "", err
+		}
+		if arg == "" {
+			return "", p.errorf("empty attribute name")
+		}
+		return "/@" + arg, nil
+	}
+	return "", p.errorf("unsupported pseudo-element ::%s", name)
+}
+
+func (p *cssParser) parsePseudoClass() (string, error) {
+	p.pos++ // :
+	name, err := p.parseIdent()
+	if err != nil {
+		return "", err
+	}
+	name = strings.ToLower(name)
+	switch name {
+	case "first-child":
+		return "not(preceding-sibling::*)", nil
+	case "last-child":
+		return "not(following-sibling::*)", nil
+	case "only-child":
+		return "not(preceding-sibling::*) and not(following-sibling::*)", nil
+	case "empty":
+		return "not(*) and not(text())", nil
+	}
+	arg, err := p.parseParens()
+	if err != nil {
+		return "", err
+	}
+	switch name {
+	case "nth-child":
+		return nthChild(arg, "count(preceding-sibling::*)+1")
+	case "nth-last-child":
+		return nthChild(arg, "count(following-sibling::*)+1")
+	case "contains":
+		text, err := (&cssParser{s: arg}).parseString()
+		if err != nil {
+			return "", err
+		}
+		return "contains(string(.), " + xpathLiteral(text) + ")", nil
+	case "not":
+		sub := &cssParser{s: arg}
+		tag, preds, pseudo, err := sub.parseCompound()
+		if err != nil {
+			return "", err
+		}
+		if pseudo != "" || !sub.eof() {
+			return "", p.errorf("only simple selectors are supported in :not")
+		}
+		return "not(self::" + tag + preds + ")", nil
+	}
+	return "", p.errorf("unsupported pseudo-class :%s", name)
+}
+
+// nthChild translates the an+b argument of :nth-child to an xpath predicate
+func nthChild(arg, position string) (string, error) {
+	arg = strings.ToLower(strings.Replace(arg, " ", "", -1))
+	var a, b int
+	var err error
+	switch {
+	case arg == "odd":
+		a, b = 2, 1
+	case arg == "even":
+		a, b = 2, 0
+	case strings.Contains(arg, "n"):
+		parts := strings.SplitN(arg, "n", 2)
+		switch parts[0] {
+		case "", "+":
+			a = 1
+		case "-":
+			a = -1
+		default:
+			if a, err = strconv.Atoi(parts[0]); err != nil {
+				return "", fmt.Errorf("invalid nth-child %q", arg)
+			}
+		}
+		if parts[1] != "" {
+			if b, err = strconv.Atoi(parts[1]); err != nil {
+				return "", fmt.Errorf("invalid nth-child %q", arg)
+			}
+		}
+	default:
+		if b, err = strconv.Atoi(arg); err != nil {
+			return "", fmt.Errorf("invalid nth-child %q", arg)
+		}
+	}
+	switch {
+	case a == 0:
+		return fmt.Sprintf("%s=%d", position, b), nil
+	case a > 0:
+		return fmt.Sprintf("(%s-%d) mod %d=0 and %s>=%d", position, b, a, position, b), nil
+	default:
+		return fmt.Sprintf("(%d-(%s)) mod %d=0 and %s<=%d", b, position, -a, position, b), nil
+	}
+}
+
+func containsWord(attr, word string) string {
+	return fmt.Sprintf("contains(concat(' ', normalize-space(%s), ' '), %s)",
+		attr, xpathLiteral(" "+word+" "))
+}
+
+// xpathLiteral quotes s as an xpath string literal
+func xpathLiteral(s string) string {
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'"
+	}
+	if !strings.Contains(s, `"`) {
+		return `"` + s + `"`
+	}
+	parts := strings.Split(s, "'")
+	return "concat('" + strings.Join(parts, `', "'", '`) + "')"
+}
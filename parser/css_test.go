@@ -0,0 +1,46 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestCssToXpath(t *testing.T) {
+	var testcases = [][]string{
+		{"div", ".//div"},
+		{"div p", ".//div//p"},
+		{"ul > li", ".//ul/li"},
+		{"> li", "./li"},
+		{"h1 + p", ".//h1/following-sibling::*[1]/self::p"},
+		{"h1 ~ p", ".//h1/following-sibling::p"},
+		{"#main", ".//*[@id='main']"},
+		{"div.post", ".//div[contains(concat(' ', normalize-space(@class), ' '), ' post ')]"},
+		{"a[href]", ".//a[@href]"},
+		{"a[href^='http']", ".//a[starts-with(@href, 'http')]"},
+		{"a[href$='.pdf']", ".//a[substring(@href, string-length(@href)-4+1)='.pdf']"},
+		{"a[title$='文档']", ".//a[substring(@title, string-length(@title)-2+1)='文档']"},
+		{"a[title=\"it's\"]", ".//a[@title=\"it's\"]"},
+		{"li:first-child", ".//li[not(preceding-sibling::*)]"},
+		{"tr:nth-child(2)", ".//tr[count(preceding-sibling::*)+1=2]"},
+		{"tr:nth-child(odd)", ".//tr[(count(preceding-sibling::*)+1-1) mod 2=0 and count(preceding-sibling::*)+1>=1]"},
+		{"tr:not(.top)", ".//tr[not(self::*[contains(concat(' ', normalize-space(@class), ' '), ' top ')])]"},
+		{"td:contains('楼主')", ".//td[contains(string(.), '楼主')]"},
+		{"a::text", ".//a/text()"},
+		{"td.title a::attr(href)", ".//td[contains(concat(' ', normalize-space(@class), ' '), ' title ')]//a/@href"},
+		{"h1, h2::text", ".//h1 | .//h2/text()"},
+	}
+	for _, c := range testcases {
+		res, err := CssToXpath(c[0])
+		if err != nil {
+			t.Error(c[0], err)
+			continue
+		}
+		if res != c[1] {
+			t.Error(c[0], ":", res, "!=", c[1])
+		}
+	}
+	for _, s := range []string{"", "div >", "a::text b", "a[href", "p:hover", "a::attr()"} {
+		if res, err := CssToXpath(s); err == nil {
+			t.Error("expect error for", s, "got", res)
+		}
+	}
+}
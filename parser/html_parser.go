@@ -4,9 +4,8 @@ import (
 	"errors"
 	"github.com/crawlerclub/x/types"
 	t "github.com/lestrrat/go-libxml2/types"
-	"github.com/robertkrimen/otto"
+	"github.com/lestrrat/go-libxml2/xpath"
 	"strings"
-	"time"
 )
 
 func init() {
@@ -23,8 +22,12 @@ type DOMNode struct {
 	Item map[string]interface{}
 }
 
+// RuleFunc evaluates one ParseRule on a node of the parsed page,
+// different parsers share the rule tree walking by providing their own RuleFunc
+type RuleFunc func(node interface{}, rule types.ParseRule, pageUrl string, data *types.TaskData) ([]interface{}, error)
+
 var (
-	ErrEmptyXpath      = errors.New("empty xpath of node conf")
+	ErrEmptyXpath      = errors.New("empty xpath and css of node conf")
 	ErrInvalidRuleType = errors.New("invalid rule_type of node conf")
 	ErrEmptyRuleType   = errors.New("empty rule_type of node conf")
 	ErrEmptyItemKey    = errors.New("empty item_key of node conf")
@@ -34,43 +37,78 @@ func (parser HtmlParser) String() string {
 	return parser.Name
 }
 
-func (parser HtmlParser) parseNodeByRule(
-	node interface{},
-	rule types.ParseRule,
-	pageUrl string) ([]interface{}, error) {
-	if len(rule.RuleType) == 0 {
-		return nil, ErrEmptyRuleType
+func findNodes(node t.Node, expr string, namespaces map[string]string) (t.XPathResult, error) {
+	if len(namespaces) == 0 {
+		return node.Find(expr)
 	}
-	if len(rule.Xpath) == 0 {
-		return nil, ErrEmptyXpath
-	}
-	var ret []interface{}
-	nodes, err := node.(t.Node).Find(rule.Xpath)
-	// zliu
-	defer nodes.Free()
+	ctx, err := xpath.NewContext(node)
 	if err != nil {
 		return nil, err
 	}
-	for _, domNode := range nodes.NodeList() {
-		switch rule.RuleType {
-		case "dom":
-			ret = append(ret, interface{}(domNode))
-		case "url":
-			u, _ := MakeAbsoluteUrl(domNode.TextContent(), pageUrl)
-			ret = append(ret, interface{}(u))
-		case "string":
-			ret = append(ret, interface{}(strings.TrimSpace(domNode.TextContent())))
-		case "html":
-			ret = append(ret, interface{}(domNode.String()))
+	defer ctx.Free()
+	for prefix, uri := range namespaces {
+		if err = ctx.RegisterNS(prefix, uri); err != nil {
+			return nil, err
 		}
 	}
+	return ctx.Find(expr)
+}
 
+// XpathRule returns a RuleFunc evaluating rules on libxml2 nodes, css of a rule
+// is translated to xpath when xpath is empty. namespaces maps prefixes used in
+// xpath to namespace uris
+func XpathRule(namespaces map[string]string) RuleFunc {
+	return func(node interface{}, rule types.ParseRule, pageUrl string, data *types.TaskData) ([]interface{}, error) {
+		if len(rule.RuleType) == 0 {
+			return nil, ErrEmptyRuleType
+		}
+		expr := rule.Xpath
+		if len(expr) == 0 && len(rule.Css) > 0 {
+			var err error
+			if expr, err = CssToXpath(rule.Css); err != nil {
+				return nil, err
+			}
+		}
+		if len(expr) == 0 {
+			return nil, ErrEmptyXpath
+		}
+		var ret []interface{}
+		nodes, err := findNodes(node.(t.Node), expr, namespaces)
+		if err != nil {
+			return nil, err
+		}
+		// zliu
+		defer nodes.Free()
+		for _, domNode := range nodes.NodeList() {
+			switch rule.RuleType {
+			case "dom":
+				ret = append(ret, interface{}(domNode))
+			case "url":
+				u, _ := MakeAbsoluteUrl(strings.TrimSpace(domNode.TextContent()), pageUrl)
+				ret = append(ret, interface{}(u))
+			case "string":
+				ret = append(ret, interface{}(strings.TrimSpace(domNode.TextContent())))
+			case "html":
+				ret = append(ret, interface{}(domNode.String()))
+			}
+		}
+		return processValues(ret, rule, data)
+	}
+}
+
+// processValues applies the regex and js of rule to the values selected by it
+func processValues(ret []interface{}, rule types.ParseRule, data *types.TaskData) ([]interface{}, error) {
 	if len(rule.Regex) > 0 {
 		var tmpVals []interface{}
 		switch rule.RuleType {
 		case "string":
 			for _, v := range ret {
-				res, err := ParseRegex(v.(string), rule.Regex)
+				s, ok := v.(string)
+				if !ok {
+					tmpVals = append(tmpVals, v)
+					continue
+				}
+				res, err := ParseRegex(s, rule.Regex)
 				if err != nil {
 					return nil, err
 				}
@@ -82,7 +120,7 @@ func (parser HtmlParser) parseNodeByRule(
 		case "url":
 			for _, v := range ret {
 				// only keep matched urls
-				if MatchRegex(v.(string), rule.Regex) {
+				if s, ok := v.(string); ok && MatchRegex(s, rule.Regex) {
 					tmpVals = append(tmpVals, v)
 				}
 			}
@@ -91,8 +129,8 @@ func (parser HtmlParser) parseNodeByRule(
 	} // if has regex
 
 	if len(rule.Js) > 0 {
-		runtime := otto.New()
-		if _, err := runtime.Run(rule.Js); err != nil {
+		runtime, err := newRuntime(rule.Js, data)
+		if err != nil {
 			return nil, err
 		}
 		var newVals []interface{}
@@ -113,22 +151,31 @@ func (parser HtmlParser) parseNodeByRule(
 		}
 		ret = newVals
 	} // if has js
-	return ret, err
+	return ret, nil
 }
 
-func (parser HtmlParser) parseNode(
+func parseNode(
 	node interface{},
 	rules []types.ParseRule,
-	pageUrl string) ([]*DOMNode, []types.Task, map[string]interface{}, error) {
+	pageUrl string,
+	eval RuleFunc,
+	parentItem map[string]interface{},
+	data *types.TaskData) ([]*DOMNode, []types.Task, map[string]interface{}, error) {
 	var retDOMs []*DOMNode
 	var retUrls []types.Task
+	// tasks of retUrls[i:j] copy fields
+	type fieldsCopy struct {
+		i, j   int
+		fields []string
+	}
+	var copies []fieldsCopy
 	retItems := make(map[string]interface{})
 	// we may get different items from one node, so need multiple rules
 	for _, rule := range rules {
 		if len(rule.ItemKey) == 0 {
 			return nil, nil, nil, ErrEmptyItemKey
 		}
-		vals, err := parser.parseNodeByRule(node, rule, pageUrl)
+		vals, err := eval(node, rule, pageUrl, data)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -142,15 +189,15 @@ func (parser HtmlParser) parseNode(
 			}
 		} else {
 			if rule.RuleType == "url" {
+				start := len(retUrls)
 				for _, v := range vals {
-					if u, ok := v.(string); ok {
-						retUrls = append(retUrls, types.Task{
-							ParserName: rule.ItemKey,
-							Url:        u,
-							IsSeedUrl:  rule.IsSeedUrl, // 20170616
-						})
+					if task := urlTask(v, rule, pageUrl); task != nil {
+						retUrls = append(retUrls, *task)
 					}
 				}
+				if len(rule.CopyFields) > 0 {
+					copies = append(copies, fieldsCopy{start, len(retUrls), rule.CopyFields})
+				}
 			}
 
 			// string or url, treat tasks as items too
@@ -171,19 +218,25 @@ func (parser HtmlParser) parseNode(
 			}
 		}
 	}
+	// copy fields after all fields of the item are parsed
+	for _, c := range copies {
+		for i := c.i; i < c.j; i++ {
+			copyFields(&retUrls[i], c.fields, retItems, parentItem, data)
+		}
+	}
 	return retDOMs, retUrls, retItems, nil
 }
 
 func (parser HtmlParser) Parse(
 	page, pageUrl string,
-	parseConf *types.ParseConf) ([]types.Task, []map[string]interface{}, error) {
+	parseConf *types.ParseConf,
+	data *types.TaskData) ([]types.Task, []map[string]interface{}, error) {
 	if parseConf == nil {
 		return nil, nil, errors.New("parse conf is nil")
 	}
 	if len(page) == 0 {
 		return nil, nil, errors.New("page len is 0")
 	}
-	conf := parseConf.Rules
 
 	root, err := ParseHTMLString(page, "utf-8")
 	if err != nil {
@@ -191,8 +244,21 @@ func (parser HtmlParser) Parse(
 	}
 	defer root.Free()
 
+	return ParseDOM(root, pageUrl, parseConf, XpathRule(nil), data)
+}
+
+// ParseDOM walks the rule tree of parseConf from the root node, and returns
+// the generated tasks and items
+func ParseDOM(
+	root interface{},
+	pageUrl string,
+	parseConf *types.ParseConf,
+	eval RuleFunc,
+	data *types.TaskData) ([]types.Task, []map[string]interface{}, error) {
+	conf := parseConf.Rules
+
 	var domList []*DOMNode
-	rootNode := &DOMNode{Name: "root", Node: interface{}(root), Item: make(map[string]interface{})}
+	rootNode := &DOMNode{Name: "root", Node: root, Item: make(map[string]interface{})}
 	domList = append(domList, rootNode)
 
 	var retUrls []types.Task
@@ -206,15 +272,17 @@ func (parser HtmlParser) Parse(
 		parentItems := domList[0].Item
 		domList = domList[1:]
 
-		domNode.(t.Node).MakeMortal()
-		//defer domNode.(t.Node).AutoFree()
+		if n, ok := domNode.(t.Node); ok {
+			n.MakeMortal()
+			//defer n.AutoFree()
+		}
 
 		var rules []types.ParseRule // get parse_rule via domName
 		var ok bool
 		if rules, ok = conf[domName]; !ok {
 			continue // no conf for this dom
 		}
-		DOMNodes, urlList, item, err := parser.parseNode(domNode, rules, pageUrl)
+		DOMNodes, urlList, item, err := parseNode(domNode, rules, pageUrl, eval, parentItems, data)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -250,34 +318,11 @@ func (parser HtmlParser) Parse(
 			retItems = append(retItems, rootItems.(map[string]interface{}))
 		}
 	}
-	if !parseConf.NoDefaultFields {
-		for _, v := range retItems {
-			v["from_url_"] = pageUrl
-			v["from_parser_name_"] = parseConf.ParserName
-			v["crawl_time_"] = time.Now().Format("2006-01-02 15:04:05")
-		}
-	}
-
-	if len(parseConf.PostProcessor) > 0 && len(retItems) > 0 {
-		runtime := otto.New()
-		if _, err := runtime.Run(parseConf.PostProcessor); err != nil {
-			return nil, nil, err
-		}
-
-		jsVal, err := runtime.ToValue(retItems)
-		result, err := runtime.Call("process", nil, jsVal)
-		if err != nil {
-			return nil, nil, err
-		}
-
-		s, err := result.Export()
-		if err != nil {
-			return nil, nil, err
-		}
-		value, ok := s.([]map[string]interface{})
-		if ok && len(value) > 0 {
-			retItems = value
-		}
+	addDefaultFields(retItems, pageUrl, parseConf)
+	mergeContext(retItems, data)
+	retItems, err := postProcess(retItems, parseConf.PostProcessor, data)
+	if err != nil {
+		return nil, nil, err
 	}
 	return retUrls, retItems, nil
 }
@@ -4,5 +4,6 @@ import "github.com/crawlerclub/x/types"
 
 type Parser interface {
 	String() string
-	Parse(page, pageUrl string, parseConf *types.ParseConf) ([]types.Task, []map[string]interface{}, error)
+	// data is the data of the task of the page, it may be nil
+	Parse(page, pageUrl string, parseConf *types.ParseConf, data *types.TaskData) ([]types.Task, []map[string]interface{}, error)
 }
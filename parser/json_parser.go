@@ -4,66 +4,117 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/crawlerclub/x/types"
-	"github.com/robertkrimen/otto"
-	"time"
+	"regexp"
+	"strings"
 )
 
 func init() {
 	Parsers["json"] = JsonParser{"json parser"}
 }
 
+// JsonParser treats the whole json object as an item when there are no
+// rules, otherwise rules are evaluated with their json_path
 type JsonParser struct {
 	Name string
 }
 
+var (
+	ErrEmptyJsonPath   = errors.New("empty json_path of node conf")
+	ErrUnsupportedJson = errors.New("json page is neither object nor array")
+)
+
+var reJsonp = regexp.MustCompile(`(?s)^\s*[\w$.]+\s*\((.*)\)\s*;?\s*$`)
+
 func (parser JsonParser) String() string {
 	return parser.Name
 }
 
-func (parser JsonParser) Parse(page, pageUrl string, parseConf *types.ParseConf) ([]types.Task, []map[string]interface{}, error) {
+// trimJsonp removes the callback wrapper of JSONP responses
+func trimJsonp(page string) string {
+	if m := reJsonp.FindStringSubmatch(page); m != nil {
+		return m[1]
+	}
+	return page
+}
+
+// JsonRule is the RuleFunc of json parser, dom rules select sub values as the
+// context of their child rules
+func JsonRule(node interface{}, rule types.ParseRule, pageUrl string, data *types.TaskData) ([]interface{}, error) {
+	if len(rule.RuleType) == 0 {
+		return nil, ErrEmptyRuleType
+	}
+	if len(rule.JsonPath) == 0 {
+		return nil, ErrEmptyJsonPath
+	}
+	vals, err := JsonPath(node, rule.JsonPath)
+	if err != nil {
+		return nil, err
+	}
+	var ret []interface{}
+	for _, v := range vals {
+		switch rule.RuleType {
+		case "dom":
+			ret = append(ret, v)
+		case "url":
+			// only string values can be urls
+			if s, ok := v.(string); ok {
+				u, _ := MakeAbsoluteUrl(strings.TrimSpace(s), pageUrl)
+				ret = append(ret, interface{}(u))
+			}
+		case "string":
+			if s, ok := v.(string); ok {
+				ret = append(ret, interface{}(strings.TrimSpace(s)))
+			} else {
+				ret = append(ret, v)
+			}
+		case "html":
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, interface{}(string(b)))
+		}
+	}
+	return processValues(ret, rule, data)
+}
+
+func (parser JsonParser) Parse(page, pageUrl string, parseConf *types.ParseConf,
+	taskData *types.TaskData) ([]types.Task, []map[string]interface{}, error) {
 	if parseConf == nil {
 		return nil, nil, errors.New("parse conf is nil")
 	}
 	if len(page) == 0 {
 		return nil, nil, errors.New("page len is 0")
 	}
-	var retUrls []types.Task
-	var retItems []map[string]interface{}
-	item := make(map[string]interface{})
-	err := json.Unmarshal([]byte(page), &item)
+	var data interface{}
+	err := json.Unmarshal([]byte(trimJsonp(page)), &data)
 	if err != nil {
 		return nil, nil, err
 	}
-	retItems = append(retItems, item)
-
-	if !parseConf.NoDefaultFields {
-		for _, v := range retItems {
-			v["from_url_"] = pageUrl
-			v["from_parser_name_"] = parseConf.ParserName
-			v["crawl_time_"] = time.Now().Format("2006-01-02 15:04:05")
-		}
+	if len(parseConf.Rules) > 0 {
+		return ParseDOM(data, pageUrl, parseConf, JsonRule, taskData)
 	}
 
-	if len(parseConf.PostProcessor) > 0 && len(retItems) > 0 {
-		runtime := otto.New()
-		if _, err := runtime.Run(parseConf.PostProcessor); err != nil {
-			return nil, nil, err
-		}
-
-		jsVal, err := runtime.ToValue(retItems)
-		result, err := runtime.Call("process", nil, jsVal)
-		if err != nil {
-			return nil, nil, err
+	var retUrls []types.Task
+	var retItems []map[string]interface{}
+	switch v := data.(type) {
+	case map[string]interface{}:
+		retItems = append(retItems, v)
+	case []interface{}:
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				retItems = append(retItems, m)
+			}
 		}
+	default:
+		return nil, nil, ErrUnsupportedJson
+	}
 
-		s, err := result.Export()
-		if err != nil {
-			return nil, nil, err
-		}
-		value, ok := s.([]map[string]interface{})
-		if ok && len(value) > 0 {
-			retItems = value
-		}
+	addDefaultFields(retItems, pageUrl, parseConf)
+	mergeContext(retItems, taskData)
+	retItems, err = postProcess(retItems, parseConf.PostProcessor, taskData)
+	if err != nil {
+		return nil, nil, err
 	}
 	return retUrls, retItems, nil
 }
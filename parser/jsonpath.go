@@ -0,0 +1,220 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidJsonPath = errors.New("invalid json path")
+)
+
+type jsonPathStep struct {
+	recursive bool   // ..
+	wildcard  bool   // * or [*]
+	key       string // .key or ['key']
+	isIndex   bool   // [n]
+	index     int
+	isSlice   bool // [start:end]
+	start     *int
+	end       *int
+}
+
+// JsonPath evaluates a JSONPath expression on a decoded json value, the
+// supported syntax is $ (or @) for the current value, .key, ['key'], [n],
+// [-n], [start:end], [*], .* and ..key for recursive descent
+func JsonPath(data interface{}, path string) ([]interface{}, error) {
+	steps, err := parseJsonPath(path)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []interface{}{data}
+	for _, step := range steps {
+		var next []interface{}
+		for _, node := range nodes {
+			if step.recursive {
+				for _, v := range jsonDescendants(node, nil) {
+					next = append(next, step.apply(v)...)
+				}
+			} else {
+				next = append(next, step.apply(node)...)
+			}
+		}
+		nodes = next
+	}
+	return nodes, nil
+}
+
+func parseJsonPath(path string) ([]*jsonPathStep, error) {
+	path = strings.TrimSpace(path)
+	if strings.HasPrefix(path, "$") || strings.HasPrefix(path, "@") {
+		path = path[1:]
+	} else if path != "" && path[0] != '.' && path[0] != '[' {
+		path = "." + path
+	}
+	var steps []*jsonPathStep
+	for len(path) > 0 {
+		step := &jsonPathStep{}
+		switch {
+		case strings.HasPrefix(path, ".."):
+			step.recursive = true
+			path = path[2:]
+			if strings.HasPrefix(path, "[") {
+				break
+			}
+			path = "." + path
+			fallthrough
+		case path[0] == '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			name := path[:end]
+			path = path[end:]
+			if name == "" {
+				return nil, fmt.Errorf("%s: empty key", ErrInvalidJsonPath)
+			}
+			if name == "*" {
+				step.wildcard = true
+			} else {
+				step.key = name
+			}
+			steps = append(steps, step)
+			continue
+		case path[0] != '[':
+			return nil, fmt.Errorf("%s: unexpected %q", ErrInvalidJsonPath, path)
+		}
+		end := strings.Index(path, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("%s: missing ]", ErrInvalidJsonPath)
+		}
+		expr := strings.TrimSpace(path[1:end])
+		path = path[end+1:]
+		if err := step.parseBracket(expr); err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func (step *jsonPathStep) parseBracket(expr string) error {
+	switch {
+	case expr == "*":
+		step.wildcard = true
+	case len(expr) >= 2 && (expr[0] == '\'' || expr[0] == '"') && expr[len(expr)-1] == expr[0]:
+		step.key = expr[1 : len(expr)-1]
+	case strings.Contains(expr, ":"):
+		step.isSlice = true
+		parts := strings.SplitN(expr, ":", 2)
+		for i, part := range parts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return fmt.Errorf("%s: %s", ErrInvalidJsonPath, err)
+			}
+			if i == 0 {
+				step.start = &n
+			} else {
+				step.end = &n
+			}
+		}
+	default:
+		n, err := strconv.Atoi(expr)
+		if err != nil {
+			return fmt.Errorf("%s: %s", ErrInvalidJsonPath, err)
+		}
+		step.isIndex = true
+		step.index = n
+	}
+	return nil
+}
+
+func (step *jsonPathStep) apply(node interface{}) []interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if step.wildcard {
+			keys := make([]string, 0, len(v))
+			for k, _ := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			var ret []interface{}
+			for _, k := range keys {
+				ret = append(ret, v[k])
+			}
+			return ret
+		}
+		if step.isIndex || step.isSlice {
+			return nil
+		}
+		if value, ok := v[step.key]; ok {
+			return []interface{}{value}
+		}
+	case []interface{}:
+		switch {
+		case step.wildcard:
+			return v
+		case step.isIndex:
+			i := step.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				return []interface{}{v[i]}
+			}
+		case step.isSlice:
+			start, end := 0, len(v)
+			if step.start != nil {
+				start = *step.start
+			}
+			if step.end != nil {
+				end = *step.end
+			}
+			if start < 0 {
+				start += len(v)
+			}
+			if end < 0 {
+				end += len(v)
+			}
+			if start < 0 {
+				start = 0
+			}
+			if end > len(v) {
+				end = len(v)
+			}
+			if start < end {
+				return v[start:end]
+			}
+		}
+	}
+	return nil
+}
+
+// jsonDescendants returns node and all its descendants in document order
+func jsonDescendants(node interface{}, ret []interface{}) []interface{} {
+	ret = append(ret, node)
+	switch v := node.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k, _ := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ret = jsonDescendants(v[k], ret)
+		}
+	case []interface{}:
+		for _, item := range v {
+			ret = jsonDescendants(item, ret)
+		}
+	}
+	return ret
+}
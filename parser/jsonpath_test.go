@@ -0,0 +1,179 @@
+package parser
+
+import (
+	"encoding/json"
+	"github.com/crawlerclub/x/types"
+	"reflect"
+	"testing"
+)
+
+const testJson = `{"data": {"list": [
+	{"id": 1, "title": " first ", "url": "/a/1"},
+	{"id": 2, "title": "second", "url": "/a/2"},
+	{"id": 3, "title": "third", "url": "/a/3"}],
+	"next": "?page=2"}}`
+
+func TestJsonPath(t *testing.T) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(testJson), &data); err != nil {
+		t.Fatal(err)
+	}
+	var testcases = []struct {
+		path string
+		want []interface{}
+	}{
+		{"$.data.next", []interface{}{"?page=2"}},
+		{"data.next", []interface{}{"?page=2"}},
+		{"$['data']['next']", []interface{}{"?page=2"}},
+		{"$.data.list[0].url", []interface{}{"/a/1"}},
+		{"$.data.list[-1].url", []interface{}{"/a/3"}},
+		{"$.data.list[*].id", []interface{}{1.0, 2.0, 3.0}},
+		{"$.data.list[1:].title", []interface{}{"second", "third"}},
+		{"$..url", []interface{}{"/a/1", "/a/2", "/a/3"}},
+		{"$.data.nothing", nil},
+	}
+	for _, c := range testcases {
+		res, err := JsonPath(data, c.path)
+		if err != nil {
+			t.Error(c.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(res, c.want) {
+			t.Error(c.path, res, "!=", c.want)
+		}
+	}
+	for _, path := range []string{"$.", "$.data[", "$.data[x]"} {
+		if _, err := JsonPath(data, path); err == nil {
+			t.Error("expect error for", path)
+		}
+	}
+}
+
+func TestJsonParserRules(t *testing.T) {
+	conf := &types.ParseConf{
+		ParserName:      "list",
+		NoDefaultFields: true,
+		Rules: map[string][]types.ParseRule{
+			"root": []types.ParseRule{
+				{RuleType: "url", ItemKey: "list", JsonPath: "$.data.next"},
+				{RuleType: "dom", ItemKey: "article", JsonPath: "$.data.list[*]"},
+			},
+			"article": []types.ParseRule{
+				{RuleType: "url", ItemKey: "detail", JsonPath: "url"},
+				{RuleType: "string", ItemKey: "title", JsonPath: "title"},
+			},
+		},
+	}
+	page := "callback(" + testJson + ");"
+	tasks, items, err := GetParser("json").Parse(page, "http://example.com/api/list", conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 4 || tasks[0].Url != "http://example.com/api/list?page=2" ||
+		tasks[3].Url != "http://example.com/a/3" || tasks[3].ParserName != "detail" {
+		t.Error("unexpected tasks: ", tasks)
+	}
+	if len(items) != 1 {
+		t.Fatal("unexpected items: ", items)
+	}
+	articles, ok := items[0]["article"].([]interface{})
+	if !ok || len(articles) != 3 {
+		t.Fatal("unexpected articles: ", items[0]["article"])
+	}
+	if title := articles[0].(map[string]interface{})["title"]; title != "first" {
+		t.Error("unexpected title: ", title)
+	}
+}
+
+func TestJsonParserTaskData(t *testing.T) {
+	conf := &types.ParseConf{
+		ParserName:      "list",
+		NoDefaultFields: true,
+		Rules: map[string][]types.ParseRule{
+			"root": []types.ParseRule{
+				{RuleType: "url", ItemKey: "list", JsonPath: "$.data.next",
+					Body: "q=go", Referer: true},
+				{RuleType: "url", ItemKey: "detail", JsonPath: "$.data.list[0].url",
+					Js: `function process(u) {
+						return {url: u, body: task.body + "&more", context: {board: task.context.board}};
+					}`},
+			},
+		},
+		PostProcessor: `function process(items) {
+			for (var i = 0; i < items.length; i++) items[i].board = task.context.board;
+			return items;
+		}`,
+	}
+	data := &types.TaskData{Body: "q=rust", Context: map[string]interface{}{"board": "Go"}}
+	tasks, items, err := GetParser("json").Parse(testJson, "http://example.com/api/list", conf, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 {
+		t.Fatal("unexpected tasks: ", tasks)
+	}
+	d := tasks[0].GetData()
+	if d.Body != "q=go" || d.Referer != "http://example.com/api/list" {
+		t.Errorf("unexpected data of %s: %+v", tasks[0].Url, d)
+	}
+	d = tasks[1].GetData()
+	if tasks[1].Url != "http://example.com/a/1" || d.Body != "q=rust&more" ||
+		d.Context["board"] != "Go" {
+		t.Errorf("unexpected data of %s: %+v", tasks[1].Url, d)
+	}
+	if len(items) != 1 || items[0]["board"] != "Go" {
+		t.Error("unexpected items: ", items)
+	}
+}
+
+func TestJsonParserCopyFields(t *testing.T) {
+	board := &types.ParseConf{
+		ParserName:      "board",
+		NoDefaultFields: true,
+		Rules: map[string][]types.ParseRule{
+			"root": []types.ParseRule{
+				{RuleType: "dom", ItemKey: "article", JsonPath: "$.data.list[*]"},
+				{RuleType: "string", ItemKey: "board_name", JsonPath: "$.data.board"},
+			},
+			"article": []types.ParseRule{
+				{RuleType: "url", ItemKey: "article", JsonPath: "url",
+					CopyFields: []string{"title", "board_name", "section", "missing"}},
+				{RuleType: "string", ItemKey: "title", JsonPath: "title"},
+			},
+		},
+	}
+	page := `{"data": {"board": "Go", "list": [{"url": "/a/1", "title": "first"}, {"url": "/a/2", "title": "second"}]}}`
+	data := &types.TaskData{Context: map[string]interface{}{"section": "Computer"}}
+	tasks, _, err := GetParser("json").Parse(page, "http://example.com/board", board, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 {
+		t.Fatal("unexpected tasks: ", tasks)
+	}
+	context := tasks[1].GetData().Context
+	want := map[string]interface{}{"title": "second", "board_name": "Go", "section": "Computer"}
+	if !reflect.DeepEqual(context, want) {
+		t.Error(context, "!=", want)
+	}
+
+	article := &types.ParseConf{
+		ParserName:      "article",
+		NoDefaultFields: true,
+		Rules: map[string][]types.ParseRule{
+			"root": []types.ParseRule{
+				{RuleType: "string", ItemKey: "title", JsonPath: "$.title"},
+				{RuleType: "string", ItemKey: "content", JsonPath: "$.content"},
+			},
+		},
+	}
+	page = `{"title": "Second", "content": "text"}`
+	_, items, err := GetParser("json").Parse(page, tasks[1].Url, article, tasks[1].GetData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]interface{}{"title": "Second", "content": "text", "board_name": "Go", "section": "Computer"}
+	if len(items) != 1 || !reflect.DeepEqual(items[0], want) {
+		t.Error(items, "!=", want)
+	}
+}
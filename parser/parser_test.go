@@ -4,8 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/crawlerclub/x/types"
-	"github.com/liuzl/dl"
+	"golang.org/x/net/html/charset"
 	"io/ioutil"
+	"net/http"
 	"testing"
 )
 
@@ -22,22 +23,24 @@ func TestParse(t *testing.T) {
 	//pageUrl := "http://www.newsmth.net/nForum/article/Orienteering/59230"
 	//pageUrl := "http://www.newsmth.net/nForum/article/Browsers/33416"
 	pageUrl := "http://www.newsmth.net/nForum/article/Taiwan/50328"
-	requestInfo := &dl.HttpRequest{
-		Url:      pageUrl,
-		Method:   "GET",
-		UseProxy: false,
-		Platform: "pc",
-	}
-
 	fmt.Println(pageUrl)
-	responseInfo := dl.Download(requestInfo)
-	if responseInfo.Error != nil {
-		t.Fatal(responseInfo.Error)
+	// crawler.Fetch is not usable here, the crawler package imports parser
+	resp, err := http.Get(pageUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
 	}
-	fmt.Println(responseInfo.Encoding)
 
-	//fmt.Println(responseInfo.Text)
-	retUrls, retItems, err := GetParser("html").Parse(responseInfo.Text, pageUrl, &urlConf)
+	//fmt.Println(string(text))
+	retUrls, retItems, err := GetParser("html").Parse(string(text), pageUrl, &urlConf, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
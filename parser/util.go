@@ -1,9 +1,13 @@
 package parser
 
 import (
+	"github.com/crawlerclub/x/types"
+	"github.com/robertkrimen/otto"
 	"golang.org/x/net/idna"
 	"net/url"
 	"regexp"
+	"strings"
+	"time"
 )
 
 func ParseRegex(content, pattern string) ([]string, error) {
@@ -57,3 +61,179 @@ func MakeAbsoluteUrl(href, baseurl string) (string, error) {
 	u = base.ResolveReference(u)
 	return u.String(), nil
 }
+
+func addDefaultFields(items []map[string]interface{}, pageUrl string, parseConf *types.ParseConf) {
+	if parseConf.NoDefaultFields {
+		return
+	}
+	for _, v := range items {
+		v["from_url_"] = pageUrl
+		v["from_parser_name_"] = parseConf.ParserName
+		v["crawl_time_"] = time.Now().Format("2006-01-02 15:04:05")
+	}
+}
+
+// copyFields copies the named fields of item, or parent, or the context of
+// the page to the context of task
+func copyFields(task *types.Task, fields []string, item, parent map[string]interface{},
+	data *types.TaskData) {
+	taskData := task.GetData()
+	for _, field := range fields {
+		v, ok := item[field]
+		if !ok {
+			v, ok = parent[field]
+		}
+		if !ok && data != nil {
+			v, ok = data.Context[field]
+		}
+		if !ok {
+			continue
+		}
+		if taskData.Context == nil {
+			taskData.Context = make(map[string]interface{})
+		}
+		taskData.Context[field] = v
+	}
+	task.SetData(taskData)
+}
+
+// mergeContext adds the fields in the context of the page to items, fields
+// parsed from the page are kept
+func mergeContext(items []map[string]interface{}, data *types.TaskData) {
+	if data == nil {
+		return
+	}
+	for _, item := range items {
+		for key, value := range data.Context {
+			if _, ok := item[key]; !ok {
+				item[key] = value
+			}
+		}
+	}
+}
+
+// newRuntime runs js in a new otto runtime, the data of the task is the
+// global variable task: {body, referer, context}
+func newRuntime(js string, data *types.TaskData) (*otto.Otto, error) {
+	runtime := otto.New()
+	if data == nil {
+		data = &types.TaskData{}
+	}
+	context := data.Context
+	if context == nil {
+		context = make(map[string]interface{})
+	}
+	err := runtime.Set("task", map[string]interface{}{
+		"body":    data.Body,
+		"referer": data.Referer,
+		"context": context,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = runtime.Run(js); err != nil {
+		return nil, err
+	}
+	return runtime, nil
+}
+
+// urlTask makes the task of a value selected by url rule, v is an url or an
+// object of {url, body, referer, context} returned by the js of rule
+func urlTask(v interface{}, rule types.ParseRule, pageUrl string) *types.Task {
+	data := &types.TaskData{Body: rule.Body}
+	if rule.Referer {
+		data.Referer = pageUrl
+	}
+	var u string
+	switch val := v.(type) {
+	case string:
+		u = val
+	case map[string]interface{}:
+		s, _ := val["url"].(string)
+		if strings.TrimSpace(s) == "" {
+			return nil
+		}
+		u, _ = MakeAbsoluteUrl(strings.TrimSpace(s), pageUrl)
+		if body, ok := val["body"].(string); ok {
+			data.Body = body
+		}
+		if referer, ok := val["referer"].(string); ok {
+			data.Referer = referer
+		}
+		if context, ok := val["context"].(map[string]interface{}); ok {
+			data.Context = context
+		}
+	default:
+		return nil
+	}
+	task := &types.Task{
+		ParserName: rule.ItemKey,
+		Url:        u,
+		IsSeedUrl:  rule.IsSeedUrl, // 20170616
+	}
+	task.SetData(data)
+	return task
+}
+
+// postProcess calls the process function defined in js on items,
+// items are kept unchanged if the result is empty
+func postProcess(items []map[string]interface{}, js string, data *types.TaskData) ([]map[string]interface{}, error) {
+	if len(js) == 0 || len(items) == 0 {
+		return items, nil
+	}
+	runtime, err := newRuntime(js, data)
+	if err != nil {
+		return nil, err
+	}
+
+	jsVal, err := runtime.ToValue(items)
+	if err != nil {
+		return nil, err
+	}
+	result, err := runtime.Call("process", nil, jsVal)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := result.Export()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := s.([]map[string]interface{})
+	if ok && len(value) > 0 {
+		items = value
+	}
+	return items, nil
+}
+
+// CanonicalUrl normalizes rawurl for deduplication: scheme and host are lower
+// cased, default port and fragment are removed, query params are sorted and
+// those in ignoreParams are dropped, a param ending with * matches a prefix
+func CanonicalUrl(rawurl string, ignoreParams []string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawurl))
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) ||
+		(u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+		u.Host = u.Host[:strings.LastIndex(u.Host, ":")]
+	}
+	u.Fragment = ""
+	u.ForceQuery = false
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	q := u.Query()
+	for key, _ := range q {
+		for _, p := range ignoreParams {
+			if key == p || (strings.HasSuffix(p, "*") && strings.HasPrefix(key, p[:len(p)-1])) {
+				q.Del(key)
+				break
+			}
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -52,3 +52,22 @@ func TestMakeAbsoluteUrl(t *testing.T) {
 		t.Log(baseurl, two[0], res)
 	}
 }
+
+func TestCanonicalUrl(t *testing.T) {
+	ignore := []string{"sid", "utm_*"}
+	var testcases = [][]string{
+		{"HTTP://WWW.Newsmth.NET:80", "http://www.newsmth.net/"},
+		{"https://example.com:443/a?b=2&a=1#top", "https://example.com/a?a=1&b=2"},
+		{"http://example.com:8080/a?sid=123&p=2&utm_source=x", "http://example.com:8080/a?p=2"},
+		{"http://example.com/a?", "http://example.com/a"},
+	}
+	for _, two := range testcases {
+		res, err := CanonicalUrl(two[0], ignore)
+		if err != nil {
+			t.Error(err)
+		}
+		if res != two[1] {
+			t.Error(res, "!=", two[1])
+		}
+	}
+}
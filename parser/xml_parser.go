@@ -0,0 +1,48 @@
+package parser
+
+import (
+	"errors"
+	"github.com/crawlerclub/x/types"
+	libxml2Parser "github.com/lestrrat/go-libxml2/parser"
+	"regexp"
+)
+
+func init() {
+	Parsers["xml"] = XmlParser{"xml parser"}
+}
+
+// XmlParser parses XML pages such as RSS, Atom and sitemaps with the same
+// ParseRules as HtmlParser, namespace prefixes used in xpath are declared in
+// ParseConf.Namespaces, e.g. {"atom": "http://www.w3.org/2005/Atom"}
+type XmlParser struct {
+	Name string
+}
+
+// pages are already converted to utf-8 by the downloader
+var reXmlEncoding = regexp.MustCompile(`^(\s*<\?xml[^>]*?encoding=)["'][^"']*["']`)
+
+func (parser XmlParser) String() string {
+	return parser.Name
+}
+
+func (parser XmlParser) Parse(
+	page, pageUrl string,
+	parseConf *types.ParseConf,
+	data *types.TaskData) ([]types.Task, []map[string]interface{}, error) {
+	if parseConf == nil {
+		return nil, nil, errors.New("parse conf is nil")
+	}
+	if len(page) == 0 {
+		return nil, nil, errors.New("page len is 0")
+	}
+	page = reXmlEncoding.ReplaceAllString(page, `${1}"utf-8"`)
+
+	p := libxml2Parser.New(libxml2Parser.XMLParseRecover, libxml2Parser.XMLParseNoNet)
+	root, err := p.ParseString(page)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer root.Free()
+
+	return ParseDOM(root, pageUrl, parseConf, XpathRule(parseConf.Namespaces), data)
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"github.com/crawlerclub/x/types"
+	"testing"
+)
+
+const testAtom = `<?xml version="1.0" encoding="gbk"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/">
+  <title>Example Feed</title>
+  <link rel="next" href="/feed?page=2"/>
+  <entry>
+    <title> First entry </title>
+    <link href="/posts/1"/>
+    <media:thumbnail url="http://img.example.com/1.png"/>
+  </entry>
+  <entry>
+    <title>Second entry</title>
+    <link href="/posts/2"/>
+    <media:thumbnail url="http://img.example.com/2.png"/>
+  </entry>
+</feed>`
+
+const testRss = `<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel>
+    <item>
+      <title>Hello</title>
+      <link>http://example.com/hello</link>
+      <dc:creator>alice</dc:creator>
+      <pubDate>Mon, 02 Jan 2006 15:04:05 GMT</pubDate>
+    </item>
+  </channel>
+</rss>`
+
+func TestXmlParserNamespaces(t *testing.T) {
+	conf := &types.ParseConf{
+		ParserName:      "feed",
+		NoDefaultFields: true,
+		Namespaces: map[string]string{
+			"atom":  "http://www.w3.org/2005/Atom",
+			"media": "http://search.yahoo.com/mrss/",
+		},
+		Rules: map[string][]types.ParseRule{
+			"root": []types.ParseRule{
+				{RuleType: "url", ItemKey: "feed", Xpath: "/atom:feed/atom:link[@rel='next']/@href"},
+				{RuleType: "dom", ItemKey: "entry", Xpath: "//atom:entry"},
+			},
+			"entry": []types.ParseRule{
+				{RuleType: "url", ItemKey: "post", Xpath: "atom:link/@href"},
+				{RuleType: "string", ItemKey: "title", Xpath: "atom:title"},
+				{RuleType: "string", ItemKey: "image", Xpath: "media:thumbnail/@url"},
+			},
+		},
+	}
+	tasks, items, err := GetParser("xml").Parse(testAtom, "http://example.com/feed", conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 3 || tasks[0].Url != "http://example.com/feed?page=2" ||
+		tasks[2].Url != "http://example.com/posts/2" || tasks[2].ParserName != "post" {
+		t.Error("unexpected tasks: ", tasks)
+	}
+	if len(items) != 1 {
+		t.Fatal("unexpected items: ", items)
+	}
+	entries, ok := items[0]["entry"].([]interface{})
+	if !ok || len(entries) != 2 {
+		t.Fatal("unexpected entries: ", items[0]["entry"])
+	}
+	entry := entries[0].(map[string]interface{})
+	if entry["title"] != "First entry" || entry["image"] != "http://img.example.com/1.png" {
+		t.Error("unexpected entry: ", entry)
+	}
+
+	// elements of the default namespace are not matched without a prefix
+	conf.Rules = map[string][]types.ParseRule{
+		"root": []types.ParseRule{{RuleType: "string", ItemKey: "title", Xpath: "/feed/title"}},
+	}
+	if _, items, err = GetParser("xml").Parse(testAtom, "http://example.com/feed", conf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0]["title"] != nil {
+		t.Error("unexpected items: ", items)
+	}
+
+	conf.Namespaces = map[string]string{"dc": "http://purl.org/dc/elements/1.1/"}
+	conf.Rules = map[string][]types.ParseRule{
+		"root": []types.ParseRule{
+			{RuleType: "string", ItemKey: "title", Xpath: "//item/title"},
+			{RuleType: "string", ItemKey: "creator", Xpath: "//item/dc:creator"},
+			// tag names of css are case sensitive in xml
+			{RuleType: "string", ItemKey: "date", Css: "item > pubDate"},
+			{RuleType: "string", ItemKey: "lower", Css: "item > pubdate"},
+		},
+	}
+	if _, items, err = GetParser("xml").Parse(testRss, "http://example.com/rss", conf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0]["title"] != "Hello" || items[0]["creator"] != "alice" ||
+		items[0]["date"] != "Mon, 02 Jan 2006 15:04:05 GMT" || items[0]["lower"] != nil {
+		t.Error("unexpected items: ", items)
+	}
+}
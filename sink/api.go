@@ -0,0 +1,111 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"github.com/crawlerclub/x/metrics"
+	"github.com/crawlerclub/x/types"
+	"strings"
+)
+
+var (
+	ErrNilSinkConf = errors.New("sink/api.go nil SinkConf")
+)
+
+var Sinks = make(map[string]NewSinkFunc)
+
+func NewSink(conf *types.SinkConf, name string) (ItemSink, error) {
+	if conf == nil {
+		return nil, ErrNilSinkConf
+	}
+	fn, ok := Sinks[conf.SinkType]
+	if !ok {
+		return nil, fmt.Errorf("no sink_type %s found!", conf.SinkType)
+	}
+	return fn(conf, name)
+}
+
+// MultiSink fans out items to all its sinks
+type MultiSink []ItemSink
+
+// NewMultiSink creates the sinks of crawler conf, es_uri is kept as an es
+// sink for compatibility
+func NewMultiSink(conf *types.CrawlerConf) (MultiSink, error) {
+	confs := conf.Sinks
+	if len(conf.EsUri) > 0 {
+		confs = append([]types.SinkConf{{SinkType: "es", Uri: conf.EsUri}}, confs...)
+	}
+	var sinks MultiSink
+	for i, _ := range confs {
+		s, err := NewSink(&confs[i], conf.CrawlerName)
+		if err != nil {
+			sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, &countingSink{s, conf.CrawlerName, confs[i].SinkType})
+	}
+	return sinks, nil
+}
+
+// countingSink counts the errors of saving items to metrics
+type countingSink struct {
+	ItemSink
+	crawler  string
+	sinkType string
+}
+
+func (self *countingSink) Save(item map[string]interface{}) error {
+	err := self.ItemSink.Save(item)
+	if err != nil {
+		metrics.SinkErrors.WithLabelValues(self.crawler, self.sinkType).Inc()
+	}
+	return err
+}
+
+func (self MultiSink) String() string {
+	var names []string
+	for _, s := range self {
+		names = append(names, s.String())
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+// Save saves item to every sink, a failed sink does not stop the others
+func (self MultiSink) Save(item map[string]interface{}) error {
+	var errs []string
+	for _, s := range self {
+		if err := s.Save(item); err != nil {
+			errs = append(errs, s.String()+": "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (self MultiSink) Flush() error {
+	var errs []string
+	for _, s := range self {
+		if err := s.Flush(); err != nil {
+			errs = append(errs, s.String()+": "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (self MultiSink) Close() error {
+	var errs []string
+	for _, s := range self {
+		if err := s.Close(); err != nil {
+			errs = append(errs, s.String()+": "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package sink
+
+import (
+	"encoding/json"
+	"github.com/crawlerclub/x/metrics"
+	"github.com/crawlerclub/x/types"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEsType        = "item"
+	defaultBulkActions   = 500
+	defaultFlushInterval = 5
+	defaultMaxRetries    = 3
+)
+
+func init() {
+	Sinks["es"] = NewEsSink
+}
+
+// EsSink indexes items into elasticsearch through a BulkProcessor, the index
+// is the crawler_name unless es_index is set, the type is the es_type field
+// of an item, or its from_parser_name_ if es_type is missing. Documents still
+// failing after max_retries are appended to the dead_letter file.
+type EsSink struct {
+	name       string
+	uri        string
+	index      string
+	es         *elastic.Client
+	bulk       *elastic.BulkProcessor
+	maxRetries int
+
+	deadLetter string
+	deadLock   sync.Mutex
+
+	// retries of failed documents run off the worker of BulkProcessor
+	retries sync.WaitGroup
+	closing chan struct{}
+}
+
+func NewEsSink(conf *types.SinkConf, name string) (ItemSink, error) {
+	es, err := elastic.NewClient(
+		elastic.SetURL(conf.Uri),
+		elastic.SetMaxRetries(10))
+	if err != nil {
+		return nil, err
+	}
+	index := conf.EsIndex
+	if index == "" {
+		index = name
+	}
+	self := &EsSink{
+		name:       name,
+		uri:        conf.Uri,
+		index:      index,
+		es:         es,
+		maxRetries: conf.MaxRetries,
+		deadLetter: conf.DeadLetter,
+		closing:    make(chan struct{}),
+	}
+	if self.maxRetries <= 0 {
+		self.maxRetries = defaultMaxRetries
+	}
+	actions, interval := conf.BulkActions, conf.FlushInterval
+	if actions <= 0 {
+		actions = defaultBulkActions
+	}
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	self.bulk, err = es.BulkProcessor().Name(name).
+		BulkActions(actions).
+		FlushInterval(time.Duration(interval) * time.Second).
+		Backoff(elastic.NewExponentialBackoff(time.Second, time.Minute)).
+		After(self.after).
+		Do(context.Background())
+	if err != nil {
+		es.Stop()
+		return nil, err
+	}
+	return self, nil
+}
+
+func (self *EsSink) String() string {
+	return "es sink " + self.uri
+}
+
+func esType(item map[string]interface{}) string {
+	for _, key := range []string{"es_type", "from_parser_name_"} {
+		if t, ok := item[key].(string); ok && t != "" {
+			return t
+		}
+	}
+	return defaultEsType
+}
+
+func (self *EsSink) Save(item map[string]interface{}) error {
+	t, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	req := elastic.NewBulkIndexRequest().Index(self.index).Type(esType(item)).
+		Doc(json.RawMessage(t))
+	if id, ok := item["id"].(string); ok && id != "" {
+		req = req.Id(id)
+	}
+	self.bulk.Add(req)
+	return nil
+}
+
+// after hands the documents failed in a bulk commit to retry without
+// blocking the worker of BulkProcessor, the whole commit is already retried
+// by the backoff of BulkProcessor if err is not nil
+func (self *EsSink) after(id int64, requests []elastic.BulkableRequest,
+	resp *elastic.BulkResponse, err error) {
+	if err != nil {
+		glog.Error(self, " drop ", len(requests), " documents: ", err)
+		self.writeDeadLetter(failedAll(requests, err))
+		return
+	}
+	failed := failedRequests(requests, resp)
+	if len(failed) == 0 {
+		return
+	}
+	self.retries.Add(1)
+	go self.retry(failed)
+}
+
+// retry indexes failed documents again with exponential backoff, documents
+// still failing after maxRetries, or when the sink is closing, are written
+// to the dead letter file
+func (self *EsSink) retry(failed []failedRequest) {
+	defer self.retries.Done()
+	for i := 0; i < self.maxRetries && len(failed) > 0; i++ {
+		select {
+		case <-self.closing:
+			self.writeDeadLetter(failed)
+			return
+		case <-time.After(time.Duration(1<<uint(i)) * time.Second):
+		}
+		requests := requestsOf(failed)
+		resp, err := self.es.Bulk().Add(requests...).Do(context.Background())
+		if err != nil {
+			failed = failedAll(requests, err)
+		} else {
+			failed = failedRequests(requests, resp)
+		}
+	}
+	if len(failed) > 0 {
+		self.writeDeadLetter(failed)
+	}
+}
+
+// failedRequest is a request failed in a bulk commit with its error
+type failedRequest struct {
+	request elastic.BulkableRequest
+	reason  string
+}
+
+// failedRequests returns the requests whose items in resp failed, items of a
+// bulk response are in the order of requests
+func failedRequests(requests []elastic.BulkableRequest,
+	resp *elastic.BulkResponse) []failedRequest {
+	if resp == nil || !resp.Errors {
+		return nil
+	}
+	var failed []failedRequest
+	for i, item := range resp.Items {
+		if i >= len(requests) {
+			break
+		}
+		for _, result := range item {
+			if result.Status >= 200 && result.Status <= 299 {
+				continue
+			}
+			reason := "status " + strconv.Itoa(result.Status)
+			if result.Error != nil {
+				reason = result.Error.Reason
+			}
+			failed = append(failed, failedRequest{request: requests[i], reason: reason})
+		}
+	}
+	return failed
+}
+
+// failedAll returns all requests failed by err
+func failedAll(requests []elastic.BulkableRequest, err error) []failedRequest {
+	failed := make([]failedRequest, len(requests))
+	for i, req := range requests {
+		failed[i] = failedRequest{request: req, reason: err.Error()}
+	}
+	return failed
+}
+
+func requestsOf(failed []failedRequest) []elastic.BulkableRequest {
+	requests := make([]elastic.BulkableRequest, len(failed))
+	for i, f := range failed {
+		requests[i] = f.request
+	}
+	return requests
+}
+
+type deadLetterRecord struct {
+	Time   string          `json:"time"`
+	Error  string          `json:"error"`
+	Action json.RawMessage `json:"action"`
+	Doc    json.RawMessage `json:"doc"`
+}
+
+func (self *EsSink) writeDeadLetter(failed []failedRequest) {
+	metrics.SinkErrors.WithLabelValues(self.name, "es").Add(float64(len(failed)))
+	if self.deadLetter == "" {
+		glog.Error(self, " drop ", len(failed), " failed documents")
+		return
+	}
+	self.deadLock.Lock()
+	defer self.deadLock.Unlock()
+	f, e := os.OpenFile(self.deadLetter, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if e != nil {
+		glog.Error(e)
+		return
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	now := time.Now().Format("2006-01-02 15:04:05")
+	for _, f := range failed {
+		lines, e := f.request.Source()
+		if e != nil || len(lines) < 2 {
+			continue
+		}
+		record := deadLetterRecord{Time: now, Error: f.reason,
+			Action: json.RawMessage(lines[0]), Doc: json.RawMessage(lines[1])}
+		if e = enc.Encode(record); e != nil {
+			glog.Error(e)
+			return
+		}
+	}
+}
+
+// Flush commits the documents buffered in the BulkProcessor
+func (self *EsSink) Flush() error {
+	return self.bulk.Flush()
+}
+
+func (self *EsSink) Close() error {
+	err := self.bulk.Close()
+	close(self.closing)
+	self.retries.Wait()
+	self.es.Stop()
+	return err
+}
@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"encoding/json"
+	"gopkg.in/olivere/elastic.v5"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEsSinkDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "es_sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// documents without id get their _id from elasticsearch
+	var requests []elastic.BulkableRequest
+	for _, title := range []string{"first", "second", "third"} {
+		requests = append(requests, elastic.NewBulkIndexRequest().Index("test").Type("item").
+			Doc(map[string]string{"title": title}))
+	}
+	resp := &elastic.BulkResponse{Errors: true, Items: []map[string]*elastic.BulkResponseItem{
+		{"index": {Status: 400, Error: &elastic.ErrorDetails{Reason: "mapper_parsing_exception"}}},
+		{"index": {Status: 201}},
+		{"index": {Status: 429, Error: &elastic.ErrorDetails{Reason: "rejected"}}},
+	}}
+	failed := failedRequests(requests, resp)
+	if len(failed) != 2 || failed[0].request != requests[0] || failed[1].request != requests[2] {
+		t.Fatal("unexpected failed requests: ", failed)
+	}
+
+	// the failed documents are not retried any more after closing
+	s := &EsSink{name: "test", deadLetter: filepath.Join(dir, "dead.jsonl"),
+		maxRetries: 3, closing: make(chan struct{})}
+	close(s.closing)
+	s.retries.Add(1)
+	s.retry(failed)
+	b, err := ioutil.ReadFile(s.deadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatal("unexpected dead letters: ", lines)
+	}
+	for i, want := range []string{"mapper_parsing_exception", "rejected"} {
+		var record deadLetterRecord
+		if err = json.Unmarshal([]byte(lines[i]), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Error != want {
+			t.Errorf("got error %q, want %q", record.Error, want)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package sink
+
+import "github.com/crawlerclub/x/types"
+
+// ItemSink is a destination of crawled items
+type ItemSink interface {
+	String() string
+	Save(item map[string]interface{}) error
+	// Flush writes out the buffered items
+	Flush() error
+	Close() error
+}
+
+// NewSinkFunc creates a sink of conf for crawler name
+type NewSinkFunc func(conf *types.SinkConf, name string) (ItemSink, error)
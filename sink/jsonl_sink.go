@@ -0,0 +1,110 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/crawlerclub/x/types"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+func init() {
+	Sinks["jsonl"] = NewJsonlSink
+}
+
+// JsonlSink appends items as json lines to files named
+// {uri}/{crawler_name}.{time}.jsonl, the file is rotated when it grows
+// larger than MaxSize MB or older than MaxAge seconds
+type JsonlSink struct {
+	sync.Mutex
+	dir     string
+	name    string
+	maxSize int64
+	maxAge  time.Duration
+
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+func NewJsonlSink(conf *types.SinkConf, name string) (ItemSink, error) {
+	if err := os.MkdirAll(conf.Uri, 0755); err != nil {
+		return nil, err
+	}
+	return &JsonlSink{
+		dir:     conf.Uri,
+		name:    name,
+		maxSize: conf.MaxSize * 1024 * 1024,
+		maxAge:  time.Duration(conf.MaxAge) * time.Second,
+	}, nil
+}
+
+func (self *JsonlSink) String() string {
+	return "jsonl sink " + self.dir
+}
+
+// rotate closes the current file and opens a new one, must be called with
+// lock held
+func (self *JsonlSink) rotate() error {
+	if err := self.closeFile(); err != nil {
+		return err
+	}
+	now := time.Now()
+	base := filepath.Join(self.dir, self.name+"."+now.Format("20060102150405"))
+	file := base + ".jsonl"
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			self.file = f
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		// rotated within the same second
+		file = fmt.Sprintf("%s.%d.jsonl", base, i)
+	}
+	self.size, self.created = 0, now
+	return nil
+}
+
+func (self *JsonlSink) closeFile() error {
+	if self.file == nil {
+		return nil
+	}
+	err := self.file.Close()
+	self.file = nil
+	return err
+}
+
+func (self *JsonlSink) Save(item map[string]interface{}) error {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	self.Lock()
+	defer self.Unlock()
+	if self.file == nil ||
+		(self.maxSize > 0 && self.size+int64(len(b)) > self.maxSize && self.size > 0) ||
+		(self.maxAge > 0 && time.Since(self.created) > self.maxAge) {
+		if err = self.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := self.file.Write(b)
+	self.size += int64(n)
+	return err
+}
+
+func (self *JsonlSink) Flush() error {
+	return nil
+}
+
+func (self *JsonlSink) Close() error {
+	self.Lock()
+	defer self.Unlock()
+	return self.closeFile()
+}
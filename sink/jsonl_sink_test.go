@@ -0,0 +1,42 @@
+package sink
+
+import (
+	"github.com/crawlerclub/x/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJsonlSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonl_sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewSink(&types.SinkConf{SinkType: "jsonl", Uri: dir}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := s.(*JsonlSink)
+	js.maxSize = 30 // rotate after every item
+	for _, title := range []string{"first item", "second item"} {
+		if err = s.Save(map[string]interface{}{"title": title}); err != nil {
+			t.Error(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Error(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "test.*.jsonl"))
+	if len(files) != 2 {
+		t.Fatal("expect 2 files, got", files)
+	}
+	for _, file := range files {
+		b, _ := ioutil.ReadFile(file)
+		if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 1 {
+			t.Error("expect 1 item per file, got", lines)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/crawlerclub/x/types"
+	"github.com/liuzl/store"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	Sinks["leveldb"] = NewLevelSink
+}
+
+// LevelSink stores items as json in a local leveldb under uri, the key is
+// the id field of an item, or its saving time if it has no id
+type LevelSink struct {
+	dir string
+	db  *store.LevelStore
+	seq uint64
+}
+
+func NewLevelSink(conf *types.SinkConf, name string) (ItemSink, error) {
+	db, err := store.NewLevelStore(conf.Uri)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelSink{dir: conf.Uri, db: db}, nil
+}
+
+func (self *LevelSink) String() string {
+	return "leveldb sink " + self.dir
+}
+
+func (self *LevelSink) Save(item map[string]interface{}) error {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	key, ok := item["id"].(string)
+	if !ok || key == "" {
+		key = fmt.Sprintf("%s\t%d",
+			time.Now().Format("20060102150405.000000"), atomic.AddUint64(&self.seq, 1))
+	}
+	return self.db.Put(key, b)
+}
+
+func (self *LevelSink) Flush() error {
+	return nil
+}
+
+func (self *LevelSink) Close() error {
+	return self.db.Close()
+}
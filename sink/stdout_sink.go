@@ -0,0 +1,46 @@
+package sink
+
+import (
+	"encoding/json"
+	"github.com/crawlerclub/x/types"
+	"io"
+	"os"
+	"sync"
+)
+
+func init() {
+	Sinks["stdout"] = NewStdoutSink
+}
+
+// StdoutSink writes items to stdout as json lines
+type StdoutSink struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func NewStdoutSink(conf *types.SinkConf, name string) (ItemSink, error) {
+	return &StdoutSink{w: os.Stdout}, nil
+}
+
+func (self *StdoutSink) String() string {
+	return "stdout sink"
+}
+
+func (self *StdoutSink) Save(item map[string]interface{}) error {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	self.Lock()
+	defer self.Unlock()
+	_, err = self.w.Write(append(b, '\n'))
+	return err
+}
+
+func (self *StdoutSink) Flush() error {
+	return nil
+}
+
+func (self *StdoutSink) Close() error {
+	return nil
+}
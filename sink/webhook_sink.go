@@ -0,0 +1,69 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/crawlerclub/x/types"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+func init() {
+	Sinks["webhook"] = NewWebhookSink
+}
+
+// WebhookSink posts every item as a json body to an http endpoint
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func NewWebhookSink(conf *types.SinkConf, name string) (ItemSink, error) {
+	return &WebhookSink{
+		url:     conf.Uri,
+		headers: conf.Headers,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (self *WebhookSink) String() string {
+	return "webhook sink " + self.url
+}
+
+func (self *WebhookSink) Save(item map[string]interface{}) error {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", self.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range self.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body to reuse the connection
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("post %s: %s", self.url, resp.Status)
+	}
+	return nil
+}
+
+func (self *WebhookSink) Flush() error {
+	return nil
+}
+
+func (self *WebhookSink) Close() error {
+	return nil
+}
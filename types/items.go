@@ -1,7 +1,11 @@
 package types
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
 )
 
 type StoreItem interface {
@@ -13,13 +17,70 @@ type Task struct {
 	ParserName      string `json:"parser_name" bson:"parser_name"`
 	IsSeedUrl       bool   `json:"is_seed_url" bson:"is_seed_url"`
 	Url             string `json:"url" bson:"url"`
-	Data            string `json:"data" bson:"data"`
+	Data            string `json:"data" bson:"data"` // TaskData in json
 	LastAccessTime  int64  `json:"last_access_time" bson:"last_access_time"`
 	RevisitInterval int64  `json:"revisit_interval" bson:"revisit_interval"`
+	RevisitCron     string `json:"revisit_cron" bson:"revisit_cron"`
+	Attempts        int    `json:"attempts" bson:"attempts"` // failed times
+	LastError       string `json:"last_error" bson:"last_error"`
+	// hash of the content parsed from the page, set by Crawler.Process
+	Fingerprint string `json:"-" bson:"-"`
 }
 
+// Id is crawler\turl, followed by \t and the hash of the request body for
+// tasks with a body, so tasks posting different bodies to an url differ
 func (self *Task) Id() string {
-	return self.CrawlerName + "\t" + self.Url
+	id := self.CrawlerName + "\t" + self.Url
+	if body := self.GetData().Body; body != "" {
+		id += "\t" + BodyHash(body)
+	}
+	return id
+}
+
+// BodyHash returns the hex of the first 8 bytes of sha1 of body
+func BodyHash(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:8])
+}
+
+// TaskData is carried by Task.Data in json
+type TaskData struct {
+	// request body of POST and PUT tasks
+	Body    string `json:"body,omitempty"`
+	Referer string `json:"referer,omitempty"`
+	// context of the page generating the task, e.g. board_name of a board page
+	Context map[string]interface{} `json:"context,omitempty"`
+}
+
+func (self *TaskData) IsEmpty() bool {
+	return self == nil || (self.Body == "" && self.Referer == "" && len(self.Context) == 0)
+}
+
+// GetData returns the data of task, a Data not in json object is taken as
+// the request body
+func (self *Task) GetData() *TaskData {
+	data := &TaskData{}
+	if self.Data == "" {
+		return data
+	}
+	if !strings.HasPrefix(strings.TrimSpace(self.Data), "{") ||
+		json.Unmarshal([]byte(self.Data), data) != nil {
+		return &TaskData{Body: self.Data}
+	}
+	return data
+}
+
+func (self *Task) SetData(data *TaskData) error {
+	if data.IsEmpty() {
+		self.Data = ""
+		return nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	self.Data = string(b)
+	return nil
 }
 
 func (this *Task) String() string {
@@ -31,8 +92,10 @@ type CrawlerItem struct {
 	CrawlerName string      `json:"crawler_name" bson:"crawler_name"`
 	Conf        CrawlerConf `json:"conf" bson:"conf"`
 	Weight      int         `json:"weight" bson:"weight"`
-	Status      string      `json:"status" bson:"status"`
-	CreateTime  int64       `json:"create_time" bson:"create_time"`
-	ModifyTime  int64       `json:"modify_time" bson:"modify_time"`
-	Author      string      `json:"author" bson:"author"`
+	// max tasks processed at the same time, 0 means no limit
+	MaxConcurrency int    `json:"max_concurrency" bson:"max_concurrency"`
+	Status         string `json:"status" bson:"status"`
+	CreateTime     int64  `json:"create_time" bson:"create_time"`
+	ModifyTime     int64  `json:"modify_time" bson:"modify_time"`
+	Author         string `json:"author" bson:"author"`
 }
@@ -3,6 +3,11 @@ package types
 import (
 	"errors"
 	"fmt"
+	"github.com/robfig/cron"
+	"math"
+	"regexp"
+	"strings"
+	"time"
 )
 
 var (
@@ -11,6 +16,15 @@ var (
 	ErrEmptyStartUrls         = errors.New("types/types.go empty start_urls of crawler conf")
 	ErrEmptyUrlsFile          = errors.New("types/types.go empty urls_file of crawler conf")
 	ErrNoStartRule            = errors.New("types/types.go empty start task conf rule of crawler conf")
+	ErrInvalidSitemapRule     = errors.New("types/types.go invalid sitemap rule of crawler conf")
+	ErrUnSupportedSinkType    = errors.New("types/types.go unsupported sink_type of crawler conf")
+	ErrEmptySinkUri           = errors.New("types/types.go empty uri of sink conf")
+	ErrInvalidRevisitCron     = errors.New("types/types.go invalid revisit_cron or timezone of parse conf")
+	ErrInvalidRevisitBounds   = errors.New("types/types.go max_revisit_interval less than min_revisit_interval of parse conf")
+	ErrUnSupportedMethod      = errors.New("types/types.go unsupported method of fetch conf")
+	ErrInvalidProxyOption     = errors.New("types/types.go proxy of fetch conf must be on, off or empty")
+	ErrUnSupportedLoginType   = errors.New("types/types.go unsupported login_type of login conf")
+	ErrInvalidLoginConf       = errors.New("types/types.go empty url of form login or js of js login")
 )
 
 type ParseRule struct {
@@ -22,8 +36,22 @@ type ParseRule struct {
 	// IsSeedUrl indicates whether the generated item is a seed or not
 	IsSeedUrl bool   `json:"is_seed_url" bson:"is_seed_url"`
 	Xpath     string `json:"xpath" bson:"xpath"`
-	Regex     string `json:"regex" bson:"regex"`
-	Js        string `json:"js" bson:"js"`
+	// Css is used when Xpath is empty, supports ::text and ::attr(name)
+	Css string `json:"css" bson:"css"`
+	// JsonPath is used instead of Xpath by json parser, e.g. $.data.list[*]
+	JsonPath string `json:"json_path" bson:"json_path"`
+	Regex    string `json:"regex" bson:"regex"`
+	Js       string `json:"js" bson:"js"`
+	// Body is sent as the request body of tasks generated by url rules if
+	// their method is POST or PUT, and the page url is sent as their Referer
+	// if Referer is true. The js of url rules may return an object of
+	// {url, body, referer, context} instead of an url.
+	Body    string `json:"body" bson:"body"`
+	Referer bool   `json:"referer" bson:"referer"`
+	// the named fields of the item of url rules, or of its parent item, or
+	// of the context of the page, are copied to the context of the generated
+	// tasks, and merged into the items parsed from their pages
+	CopyFields []string `json:"copy_fields" bson:"copy_fields"`
 }
 
 type ParseConf struct {
@@ -34,6 +62,157 @@ type ParseConf struct {
 	Rules           map[string][]ParseRule `json:"rules" bson:"rules"` // RuleName to ParseRules
 	PostProcessor   string                 `json:"post_processor" bson:"post_processor"`
 	RevisitInterval int64                  `json:"revisit_interval" bson:"revisit_interval"`
+	// standard cron spec of revisiting seeds, e.g. "0 */2 * * *" or "@daily",
+	// revisit_interval is ignored if it is set
+	RevisitCron string `json:"revisit_cron" bson:"revisit_cron"`
+	// timezone of revisit_cron, e.g. "Asia/Shanghai", local time if empty
+	Timezone string `json:"timezone" bson:"timezone"`
+	// if both are set, the revisit interval of each seed starts from
+	// revisit_interval and is shortened when the page changed and lengthened
+	// when not, within [min_revisit_interval, max_revisit_interval]
+	MinRevisitInterval int64             `json:"min_revisit_interval" bson:"min_revisit_interval"`
+	MaxRevisitInterval int64             `json:"max_revisit_interval" bson:"max_revisit_interval"`
+	Namespaces         map[string]string `json:"namespaces" bson:"namespaces"` // xpath prefix to namespace uri, for xml parser
+	Retry              RetryConf         `json:"retry" bson:"retry"`
+	// overrides fetch of crawler conf
+	Fetch FetchConf `json:"fetch" bson:"fetch"`
+
+	// revisit_cron parsed by Compile of the crawler conf, shared by copies
+	revisit *revisitSchedule
+}
+
+type revisitSchedule struct {
+	spec     string // RevisitSchedule() parsed
+	schedule cron.Schedule
+	loc      *time.Location
+}
+
+const (
+	defaultMaxAttempts  = 5
+	defaultInitialDelay = 60
+	defaultMaxDelay     = 3600
+	defaultMultiplier   = 2
+)
+
+// RetryConf is the exponential backoff of failed tasks, the n-th retry is
+// delayed InitialDelay * Multiplier^(n-1) seconds and at most MaxDelay
+// seconds, tasks failed MaxAttempts times are moved to the failed store
+type RetryConf struct {
+	MaxAttempts  int     `json:"max_attempts" bson:"max_attempts"`   // 5 if 0
+	InitialDelay int64   `json:"initial_delay" bson:"initial_delay"` // 60 if 0
+	MaxDelay     int64   `json:"max_delay" bson:"max_delay"`         // 3600 if 0
+	Multiplier   float64 `json:"multiplier" bson:"multiplier"`       // 2 if less than 1
+}
+
+// ShouldRetry reports whether a task failed attempts times can be retried
+func (self *RetryConf) ShouldRetry(attempts int) bool {
+	max := self.MaxAttempts
+	if max <= 0 {
+		max = defaultMaxAttempts
+	}
+	return attempts < max
+}
+
+// Delay returns the seconds to wait before retrying a task failed attempts
+// times
+func (self *RetryConf) Delay(attempts int) int64 {
+	delay, max, multiplier := self.InitialDelay, self.MaxDelay, self.Multiplier
+	if delay <= 0 {
+		delay = defaultInitialDelay
+	}
+	if max <= 0 {
+		max = defaultMaxDelay
+	}
+	if multiplier < 1 {
+		multiplier = defaultMultiplier
+	}
+	d := float64(delay) * math.Pow(multiplier, float64(attempts-1))
+	if d > float64(max) {
+		return max
+	}
+	return int64(d)
+}
+
+// Revisits reports whether the seeds parsed by this conf are crawled again
+func (this *ParseConf) Revisits() bool {
+	return this.RevisitCron != "" || this.RevisitInterval > 0 || this.AdaptiveRevisit()
+}
+
+// AdaptiveRevisit reports whether revisit intervals of seeds adapt to the
+// changes of pages, revisit_cron disables it
+func (this *ParseConf) AdaptiveRevisit() bool {
+	return this.RevisitCron == "" && this.MinRevisitInterval > 0 &&
+		this.MaxRevisitInterval >= this.MinRevisitInterval
+}
+
+// AdaptRevisitInterval returns the revisit interval of a seed after a visit,
+// it is halved if the page changed and grows by half, at least 1 second, if
+// not. interval is the current one, revisit_interval is used if it is 0.
+func (this *ParseConf) AdaptRevisitInterval(interval int64, changed bool) int64 {
+	if interval <= 0 {
+		interval = this.RevisitInterval
+	} else if changed {
+		interval /= 2
+	} else if interval < 2 {
+		interval++
+	} else {
+		interval += interval / 2
+	}
+	if interval < this.MinRevisitInterval {
+		interval = this.MinRevisitInterval
+	}
+	if interval > this.MaxRevisitInterval {
+		interval = this.MaxRevisitInterval
+	}
+	return interval
+}
+
+// RevisitSchedule returns revisit_cron prefixed by its timezone, it is kept
+// in seeds to find changes of the conf
+func (this *ParseConf) RevisitSchedule() string {
+	if this.RevisitCron == "" || this.Timezone == "" {
+		return this.RevisitCron
+	}
+	return "TZ=" + this.Timezone + " " + this.RevisitCron
+}
+
+func (this *ParseConf) cronSchedule() (cron.Schedule, *time.Location, error) {
+	if r := this.revisit; r != nil && r.spec == this.RevisitSchedule() {
+		return r.schedule, r.loc, nil
+	}
+	loc := time.Local
+	if this.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(this.Timezone); err != nil {
+			return nil, nil, err
+		}
+	}
+	schedule, err := cron.ParseStandard(this.RevisitCron)
+	if err != nil {
+		return nil, nil, err
+	}
+	return schedule, loc, nil
+}
+
+// NextRevisit returns the unix time to crawl a seed visited at last again, 0
+// if it is not revisited
+func (this *ParseConf) NextRevisit(last int64) int64 {
+	if this.RevisitCron == "" {
+		if this.RevisitInterval > 0 {
+			return last + this.RevisitInterval
+		}
+		return 0
+	}
+	schedule, loc, err := this.cronSchedule()
+	if err != nil {
+		return 0
+	}
+	next := schedule.Next(time.Unix(last, 0).In(loc))
+	if next.IsZero() {
+		// never fires, e.g. 0 0 30 2 *
+		return 0
+	}
+	return next.Unix()
 }
 
 func (this *ParseConf) String() string {
@@ -50,6 +229,238 @@ type CrawlerConf struct {
 	ParseConfs      map[string]ParseConf `json:"parse_confs" bson:"parse_confs"`
 	StartParserName string               `json:"start_parser_name" bson:"start_parser_name"`
 	EsUri           string               `json:"es_uri" bson:"es_uri"`
+	Sinks           []SinkConf           `json:"sinks" bson:"sinks"`
+	Sitemap         SitemapConf          `json:"sitemap" bson:"sitemap"`
+	Politeness      PolitenessConf       `json:"politeness" bson:"politeness"`
+	Dedup           DedupConf            `json:"dedup" bson:"dedup"`
+	Fetch           FetchConf            `json:"fetch" bson:"fetch"`
+	Login           LoginConf            `json:"login" bson:"login"`
+}
+
+// LoginConf declares how a crawler logs in, the cookies of its session are
+// persisted by controller. Two login_types: form and js, no login if empty.
+type LoginConf struct {
+	LoginType string `json:"login_type" bson:"login_type"`
+	// form logins post Form to Url
+	Url  string            `json:"url" bson:"url"`
+	Form map[string]string `json:"form" bson:"form"`
+	// js logins run Js with the function request(method, url, body) which
+	// returns {status, url, text}, they fail if Js throws or returns false
+	Js string `json:"js" bson:"js"`
+	// the login fails if its last page does not match SuccessPattern
+	SuccessPattern string `json:"success_pattern" bson:"success_pattern"`
+	// pages matching LoggedOutPattern are fetched again after logging in
+	LoggedOutPattern string `json:"logged_out_pattern" bson:"logged_out_pattern"`
+}
+
+func (self *LoginConf) Enabled() bool {
+	return self.LoginType != ""
+}
+
+func (self *LoginConf) isValid() error {
+	switch self.LoginType {
+	case "":
+		return nil
+	case "form":
+		if self.Url == "" {
+			return ErrInvalidLoginConf
+		}
+	case "js":
+		if self.Js == "" {
+			return ErrInvalidLoginConf
+		}
+	default:
+		return ErrUnSupportedLoginType
+	}
+	for _, pattern := range []string{self.SuccessPattern, self.LoggedOutPattern} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchConf controls how pages are requested, the fetch of a parse conf
+// overrides the one of its crawler conf by its non-empty fields
+type FetchConf struct {
+	// GET if empty, data of tasks is sent as the body of POST and PUT
+	Method string `json:"method" bson:"method"`
+	// content type of the body, application/x-www-form-urlencoded if empty
+	BodyType  string            `json:"body_type" bson:"body_type"`
+	Headers   map[string]string `json:"headers" bson:"headers"`
+	UserAgent string            `json:"user_agent" bson:"user_agent"`
+	Cookies   map[string]string `json:"cookies" bson:"cookies"` // name to value
+	Timeout   int               `json:"timeout" bson:"timeout"` // seconds, 60 if 0
+	// on or off, off if empty. Proxies of the pool of crawlerd are used if
+	// on, or the proxy of environment if the pool is empty
+	Proxy string `json:"proxy" bson:"proxy"`
+	// pages matching CaptchaPattern fail and ban their proxies
+	CaptchaPattern string `json:"captcha_pattern" bson:"captcha_pattern"`
+	MaxBodySize    int64  `json:"max_body_size" bson:"max_body_size"` // bytes, 10MB if 0
+	// allowed media types, e.g. text/html or text/*, all if empty
+	ContentTypes []string `json:"content_types" bson:"content_types"`
+
+	// CaptchaPattern compiled by Compile of the crawler conf, shared by copies
+	captcha *regexp.Regexp
+}
+
+// Captcha returns the regexp of CaptchaPattern, nil if it is empty or invalid
+func (self *FetchConf) Captcha() *regexp.Regexp {
+	if self.CaptchaPattern == "" {
+		return nil
+	}
+	if self.captcha != nil && self.captcha.String() == self.CaptchaPattern {
+		return self.captcha
+	}
+	re, _ := regexp.Compile(self.CaptchaPattern)
+	return re
+}
+
+// Merge returns a copy of self overridden by the non-empty fields of o,
+// headers and cookies are merged
+func (self *FetchConf) Merge(o *FetchConf) *FetchConf {
+	ret := *self
+	if o == nil {
+		return &ret
+	}
+	if o.Method != "" {
+		ret.Method = o.Method
+	}
+	if o.BodyType != "" {
+		ret.BodyType = o.BodyType
+	}
+	ret.Headers = mergeMap(self.Headers, o.Headers)
+	if o.UserAgent != "" {
+		ret.UserAgent = o.UserAgent
+	}
+	ret.Cookies = mergeMap(self.Cookies, o.Cookies)
+	if o.Timeout > 0 {
+		ret.Timeout = o.Timeout
+	}
+	if o.Proxy != "" {
+		ret.Proxy = o.Proxy
+	}
+	if o.CaptchaPattern != "" {
+		ret.CaptchaPattern, ret.captcha = o.CaptchaPattern, o.captcha
+	}
+	if o.MaxBodySize > 0 {
+		ret.MaxBodySize = o.MaxBodySize
+	}
+	if len(o.ContentTypes) > 0 {
+		ret.ContentTypes = o.ContentTypes
+	}
+	return &ret
+}
+
+func mergeMap(a, b map[string]string) map[string]string {
+	if len(b) == 0 {
+		return a
+	}
+	if len(a) == 0 {
+		return b
+	}
+	ret := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		ret[k] = v
+	}
+	for k, v := range b {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (self *FetchConf) isValid() error {
+	switch strings.ToUpper(self.Method) {
+	case "", "GET", "POST", "PUT", "HEAD":
+	default:
+		return ErrUnSupportedMethod
+	}
+	switch self.Proxy {
+	case "", "on", "off":
+	default:
+		return ErrInvalidProxyOption
+	}
+	if self.CaptchaPattern == "" {
+		return nil
+	}
+	_, err := regexp.Compile(self.CaptchaPattern)
+	return err
+}
+
+func (self *FetchConf) compile() {
+	if self.CaptchaPattern != "" {
+		self.captcha, _ = regexp.Compile(self.CaptchaPattern)
+	}
+}
+
+// DedupConf controls the deduplication of urls before they are enqueued, an
+// url is crawled only once, seeds are crawled again after revisit_interval
+type DedupConf struct {
+	Disabled bool `json:"disabled" bson:"disabled"`
+	// query params dropped when canonicalizing urls, e.g. sid or utm_*
+	IgnoreParams []string `json:"ignore_params" bson:"ignore_params"`
+	// keep a bloom filter of seen urls in memory to save store lookups
+	BloomFilter bool `json:"bloom_filter" bson:"bloom_filter"`
+	// expected number of urls of the bloom filter, 1000000 if 0
+	BloomCapacity int `json:"bloom_capacity" bson:"bloom_capacity"`
+}
+
+// SinkConf is a destination of crawled items, five sink_types: jsonl,
+// leveldb, stdout, webhook, es
+type SinkConf struct {
+	SinkType string `json:"sink_type" bson:"sink_type"`
+	// directory of jsonl and leveldb, url of webhook and es
+	Uri string `json:"uri" bson:"uri"`
+	// jsonl files are rotated when larger than MaxSize MB or older than
+	// MaxAge seconds, 0 means no limit
+	MaxSize int64 `json:"max_size" bson:"max_size"`
+	MaxAge  int64 `json:"max_age" bson:"max_age"`
+	// http headers of webhook requests
+	Headers map[string]string `json:"headers" bson:"headers"`
+	// es index name, crawler_name if empty
+	EsIndex string `json:"es_index" bson:"es_index"`
+	// es documents are committed in bulks of BulkActions (500 if 0) or every
+	// FlushInterval seconds (5 if 0)
+	BulkActions   int   `json:"bulk_actions" bson:"bulk_actions"`
+	FlushInterval int64 `json:"flush_interval" bson:"flush_interval"`
+	// failed es documents are retried MaxRetries times (3 if 0), and then
+	// appended to the DeadLetter file if it is set
+	MaxRetries int    `json:"max_retries" bson:"max_retries"`
+	DeadLetter string `json:"dead_letter" bson:"dead_letter"`
+}
+
+// PolitenessConf controls how a crawler treats the hosts it crawls, the limits
+// of a host are shared by all workers
+type PolitenessConf struct {
+	ObeyRobots bool `json:"obey_robots" bson:"obey_robots"`
+	// the agent name matched against User-agent lines of robots.txt
+	RobotsAgent string `json:"robots_agent" bson:"robots_agent"`
+	// milliseconds between two requests to the same host, the larger one of
+	// HostDelay and Crawl-delay of robots.txt is used
+	HostDelay int64 `json:"host_delay" bson:"host_delay"`
+	// max concurrent requests to the same host, 0 means no limit
+	HostConcurrency int `json:"host_concurrency" bson:"host_concurrency"`
+}
+
+// SitemapConf discovers seeds of navigation crawlers from sitemaps
+type SitemapConf struct {
+	// read the Sitemap: lines of robots.txt of start_urls hosts
+	Robots      bool     `json:"robots" bson:"robots"`
+	SitemapUrls []string `json:"sitemap_urls" bson:"sitemap_urls"`
+	// the first matched rule decides the parser_name of an url, urls
+	// matching no rules are dropped, start_parser_name is used if no rules
+	Rules []SitemapRule `json:"rules" bson:"rules"`
+	// seconds to read sitemaps again, 0 means only once
+	RevisitInterval int64 `json:"revisit_interval" bson:"revisit_interval"`
+}
+
+type SitemapRule struct {
+	Regex      string `json:"regex" bson:"regex"`
+	ParserName string `json:"parser_name" bson:"parser_name"`
+}
+
+func (self *SitemapConf) Enabled() bool {
+	return self.Robots || len(self.SitemapUrls) > 0
 }
 
 func (self *CrawlerConf) Type() string {
@@ -79,5 +490,58 @@ func (conf *CrawlerConf) IsValid() (bool, error) {
 	if _, ok := conf.ParseConfs[conf.StartParserName]; !ok {
 		return false, ErrNoStartRule
 	}
+	if err := conf.Fetch.isValid(); err != nil {
+		return false, err
+	}
+	if err := conf.Login.isValid(); err != nil {
+		return false, err
+	}
+	for _, p := range conf.ParseConfs {
+		if err := p.Fetch.isValid(); err != nil {
+			return false, err
+		}
+		if p.MinRevisitInterval > 0 && p.MaxRevisitInterval < p.MinRevisitInterval {
+			return false, ErrInvalidRevisitBounds
+		}
+		if p.RevisitCron != "" {
+			if _, _, err := p.cronSchedule(); err != nil {
+				return false, ErrInvalidRevisitCron
+			}
+		}
+	}
+	for _, rule := range conf.Sitemap.Rules {
+		if _, ok := conf.ParseConfs[rule.ParserName]; !ok {
+			return false, ErrInvalidSitemapRule
+		}
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			return false, ErrInvalidSitemapRule
+		}
+	}
+	for _, sink := range conf.Sinks {
+		switch sink.SinkType {
+		case "stdout":
+		case "jsonl", "leveldb", "webhook", "es":
+			if sink.Uri == "" {
+				return false, ErrEmptySinkUri
+			}
+		default:
+			return false, ErrUnSupportedSinkType
+		}
+	}
 	return true, nil
 }
+
+// Compile parses revisit_cron and compiles captcha_pattern of a valid conf
+// once, so they are not parsed again by NextRevisit and Captcha of tasks
+func (conf *CrawlerConf) Compile() {
+	conf.Fetch.compile()
+	for name, p := range conf.ParseConfs {
+		p.Fetch.compile()
+		if p.RevisitCron != "" {
+			if schedule, loc, err := p.cronSchedule(); err == nil {
+				p.revisit = &revisitSchedule{spec: p.RevisitSchedule(), schedule: schedule, loc: loc}
+			}
+		}
+		conf.ParseConfs[name] = p
+	}
+}
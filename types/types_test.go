@@ -0,0 +1,178 @@
+package types
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryConf(t *testing.T) {
+	var conf RetryConf
+	var delays []int64
+	for i := 1; conf.ShouldRetry(i); i++ {
+		delays = append(delays, conf.Delay(i))
+	}
+	if len(delays) != 4 || delays[0] != 60 || delays[3] != 480 {
+		t.Error("unexpected default delays:", delays)
+	}
+	conf = RetryConf{MaxAttempts: 10, InitialDelay: 10, MaxDelay: 100, Multiplier: 3}
+	if d := conf.Delay(3); d != 90 {
+		t.Error(d, "!= 90")
+	}
+	if d := conf.Delay(4); d != 100 {
+		t.Error(d, "!= 100")
+	}
+}
+
+func TestNextRevisit(t *testing.T) {
+	last := time.Date(2018, 1, 1, 10, 30, 0, 0, time.UTC).Unix()
+	conf := ParseConf{RevisitInterval: 600}
+	if next := conf.NextRevisit(last); next != last+600 {
+		t.Error(next, "!=", last+600)
+	}
+	conf.RevisitCron = "0 */2 * * *"
+	conf.Timezone = "UTC"
+	want := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC).Unix()
+	if next := conf.NextRevisit(last); next != want {
+		t.Error(time.Unix(next, 0).UTC(), "!=", time.Unix(want, 0).UTC())
+	}
+	conf.RevisitCron = "0 8 * * *"
+	conf.Timezone = "Asia/Shanghai"
+	// 08:00 +0800 is 00:00 UTC
+	want = time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC).Unix()
+	if next := conf.NextRevisit(last); next != want {
+		t.Error(time.Unix(next, 0).UTC(), "!=", time.Unix(want, 0).UTC())
+	}
+	if s := conf.RevisitSchedule(); s != "TZ=Asia/Shanghai 0 8 * * *" {
+		t.Error(s)
+	}
+	// parsed once by Compile, not by IsValid
+	crawlerConf := CrawlerConf{CrawlerName: "c", CrawlerType: "navigation",
+		StartUrls: []string{"http://example.com/"}, StartParserName: "p",
+		ParseConfs: map[string]ParseConf{"p": conf}}
+	if ok, err := crawlerConf.IsValid(); !ok {
+		t.Fatal(err)
+	}
+	if crawlerConf.ParseConfs["p"].revisit != nil {
+		t.Error("revisit_cron is parsed by IsValid")
+	}
+	crawlerConf.Compile()
+	conf = crawlerConf.ParseConfs["p"]
+	if conf.revisit == nil {
+		t.Error("revisit_cron is not parsed by Compile")
+	}
+	if next := conf.NextRevisit(last); next != want {
+		t.Error(time.Unix(next, 0).UTC(), "!=", time.Unix(want, 0).UTC())
+	}
+	conf.RevisitCron = "0 0 30 2 *"
+	if next := conf.NextRevisit(last); next != 0 {
+		t.Error("never fires, but got", next)
+	}
+	conf = ParseConf{}
+	if conf.Revisits() || conf.NextRevisit(last) != 0 {
+		t.Error("revisited without revisit_interval or revisit_cron")
+	}
+}
+
+func TestAdaptRevisitInterval(t *testing.T) {
+	conf := ParseConf{RevisitInterval: 3600, MinRevisitInterval: 600, MaxRevisitInterval: 7200}
+	if !conf.AdaptiveRevisit() {
+		t.Fatal("adaptive revisit not enabled")
+	}
+	interval := conf.AdaptRevisitInterval(0, false)
+	if interval != 3600 {
+		t.Error(interval, "!= 3600")
+	}
+	var got []int64
+	for _, changed := range []bool{true, true, true, false, false, false, false, false, false} {
+		interval = conf.AdaptRevisitInterval(interval, changed)
+		got = append(got, interval)
+	}
+	want := []int64{1800, 900, 600, 900, 1350, 2025, 3037, 4555, 6832}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatal(got, "!=", want)
+		}
+	}
+	if interval = conf.AdaptRevisitInterval(interval, false); interval != 7200 {
+		t.Error(interval, "!= 7200")
+	}
+	// small intervals grow too
+	conf = ParseConf{RevisitInterval: 1, MinRevisitInterval: 1, MaxRevisitInterval: 10}
+	got = got[:0]
+	for interval = 0; len(got) < 4; {
+		interval = conf.AdaptRevisitInterval(interval, false)
+		got = append(got, interval)
+	}
+	if want = []int64{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Error(got, "!=", want)
+	}
+	conf.RevisitCron = "@daily"
+	if conf.AdaptiveRevisit() {
+		t.Error("adaptive revisit enabled with revisit_cron")
+	}
+}
+
+func TestTaskData(t *testing.T) {
+	task := &Task{Data: "a=1&b=2"}
+	if d := task.GetData(); d.Body != "a=1&b=2" {
+		t.Error("raw data is not taken as body:", d)
+	}
+	task.SetData(&TaskData{Referer: "http://a.com/", Context: map[string]interface{}{"k": "v"}})
+	if d := task.GetData(); d.Referer != "http://a.com/" || d.Context["k"] != "v" {
+		t.Error("unexpected data:", d)
+	}
+	task.SetData(&TaskData{})
+	if task.Data != "" {
+		t.Error("empty data is not cleared:", task.Data)
+	}
+}
+
+func TestTaskId(t *testing.T) {
+	get := &Task{CrawlerName: "c", Url: "http://a.com/search"}
+	if id := get.Id(); id != "c\thttp://a.com/search" {
+		t.Error("unexpected id: ", id)
+	}
+	post1, post2 := *get, *get
+	post1.SetData(&TaskData{Body: "q=1", Referer: "http://a.com/"})
+	post2.Data = "q=2"
+	if post1.Id() == get.Id() || post1.Id() == post2.Id() {
+		t.Error("tasks posting different bodies have the same id: ", post1.Id(), post2.Id())
+	}
+	if !strings.HasPrefix(post1.Id(), get.Id()+"\t") {
+		t.Error("unexpected id: ", post1.Id())
+	}
+	post2.SetData(&TaskData{Body: "q=1"})
+	if post1.Id() != post2.Id() {
+		t.Error("ids of the same body differ: ", post1.Id(), post2.Id())
+	}
+}
+
+func TestFetchConfCaptcha(t *testing.T) {
+	conf := CrawlerConf{CrawlerName: "c", CrawlerType: "navigation",
+		StartUrls: []string{"http://example.com/"}, StartParserName: "p",
+		Fetch:      FetchConf{CaptchaPattern: "captcha"},
+		ParseConfs: map[string]ParseConf{"p": {Fetch: FetchConf{CaptchaPattern: "verify"}}}}
+	if ok, err := conf.IsValid(); !ok {
+		t.Fatal(err)
+	}
+	if conf.Fetch.captcha != nil || conf.ParseConfs["p"].Fetch.captcha != nil {
+		t.Error("captcha_pattern is compiled by IsValid")
+	}
+	conf.Compile()
+	p := conf.ParseConfs["p"]
+	if conf.Fetch.captcha == nil || p.Fetch.captcha == nil {
+		t.Fatal("captcha_pattern is not compiled by Compile")
+	}
+	// the compiled one is kept by Merge
+	if re := conf.Fetch.Merge(&p.Fetch).Captcha(); re != p.Fetch.captcha {
+		t.Error("captcha_pattern is compiled again")
+	}
+	if re := conf.Fetch.Merge(nil).Captcha(); re != conf.Fetch.captcha {
+		t.Error("captcha_pattern is compiled again")
+	}
+	if re := (&FetchConf{}).Captcha(); re != nil {
+		t.Error("got captcha regexp of empty pattern", re)
+	}
+}